@@ -0,0 +1,206 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.googleblog.com/2014/04/best-practices-arrays-in-firebase.html
+
+package firebasedb
+
+// The database has no arrays: a node whose keys are mostly integers is returned as
+// a JSON array (with null for the missing keys), while the same node is returned as
+// an object when its keys are sparse. The types List, IntMap and StringMap accept
+// both forms, so that the decoding does not depend on the content of the node.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// arrayOrObject splits a JSON array or object into its children. The elements of an
+// array are indexed by their position and the null elements are skipped.
+func arrayOrObject(data []byte) (map[string]json.RawMessage, error) {
+	var items map[string]json.RawMessage
+	if err := json.Unmarshal(data, &items); err == nil {
+		return items, nil
+	}
+	var a []json.RawMessage
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, errors.New(fmt.Sprintf("Expected a JSON array or object: %v", err))
+	}
+	items = map[string]json.RawMessage{}
+	for i, item := range a {
+		if string(bytes.TrimSpace(item)) != "null" {
+			items[strconv.Itoa(i)] = item
+		}
+	}
+	return items, nil
+}
+
+// indexKey returns the integer value of the key of an element of a list.
+func indexKey(key string) (int, error) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 {
+		return 0, errors.New(fmt.Sprintf("Invalid index %q", key))
+	}
+	return i, nil
+}
+
+// List is a slice that can be decoded from a JSON array or from an object with
+// integer keys. The missing elements are set to the zero value of T. Like the
+// database, which returns a node as an array only if more than half of its keys
+// are used, List rejects the objects whose keys are too sparse.
+type List[T any] []T
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *List[T]) UnmarshalJSON(data []byte) error {
+	return l.decodeWithCodec(defaultCodec, data)
+}
+
+// decodeWithCodec decodes the elements of the list with codec.
+func (l *List[T]) decodeWithCodec(codec Codec, data []byte) error {
+	items, err := arrayOrObject(data)
+	if err != nil {
+		return err
+	}
+	if items == nil {
+		*l = nil
+		return nil
+	}
+	keys := make([]int, 0, len(items))
+	for k := range items {
+		i, err := indexKey(k)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, i)
+	}
+	sort.Ints(keys)
+	result := List[T]{}
+	if len(keys) > 0 {
+		last := keys[len(keys)-1]
+		if last >= 2*len(keys) {
+			return errors.New(fmt.Sprintf("Index %v is too large for a list of %v elements", last, len(keys)))
+		}
+		result = make(List[T], last+1)
+	}
+	for _, i := range keys {
+		k := strconv.Itoa(i)
+		if err = unmarshalValue(codec, items[k], &result[i], k); err != nil {
+			return err
+		}
+	}
+	*l = result
+	return nil
+}
+
+// IntMap is a map with integer keys that can be decoded from a JSON array or from
+// an object. The null elements of an array are skipped.
+type IntMap[T any] map[int]T
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *IntMap[T]) UnmarshalJSON(data []byte) error {
+	return m.decodeWithCodec(defaultCodec, data)
+}
+
+// decodeWithCodec decodes the elements of the map with codec.
+func (m *IntMap[T]) decodeWithCodec(codec Codec, data []byte) error {
+	items, err := arrayOrObject(data)
+	if err != nil {
+		return err
+	}
+	if items == nil {
+		*m = nil
+		return nil
+	}
+	result := IntMap[T]{}
+	for k, item := range items {
+		i, err := strconv.Atoi(k)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Invalid integer key %q", k))
+		}
+		var v T
+		if err = unmarshalValue(codec, item, &v, k); err != nil {
+			return err
+		}
+		result[i] = v
+	}
+	*m = result
+	return nil
+}
+
+// StringMap is a map that can be decoded from a JSON object or from an array, in
+// which case the keys are the positions of the elements. The null elements of an
+// array are skipped.
+type StringMap[T any] map[string]T
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *StringMap[T]) UnmarshalJSON(data []byte) error {
+	return m.decodeWithCodec(defaultCodec, data)
+}
+
+// decodeWithCodec decodes the elements of the map with codec.
+func (m *StringMap[T]) decodeWithCodec(codec Codec, data []byte) error {
+	items, err := arrayOrObject(data)
+	if err != nil {
+		return err
+	}
+	if items == nil {
+		*m = nil
+		return nil
+	}
+	result := StringMap[T]{}
+	for k, item := range items {
+		var v T
+		if err = unmarshalValue(codec, item, &v, k); err != nil {
+			return err
+		}
+		result[k] = v
+	}
+	*m = result
+	return nil
+}
+
+// ArraysAsObjects returns a reference that writes the slices and arrays as objects
+// indexed by the position of the elements. The nil elements are not written. It is
+// used to store lists that are accessed by index, so that the database keeps an
+// object with integer keys instead of an array. Note that the database may still
+// return such objects as arrays: use List, IntMap or StringMap to read them.
+func (r *Reference) ArraysAsObjects(value bool) *Reference {
+	result := *r
+	result.arraysAsObjects = value
+	return &result
+}
+
+// objectForm converts the arrays of a decoded JSON value into objects.
+func objectForm(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		m := map[string]interface{}{}
+		for i, child := range v {
+			if child != nil {
+				m[strconv.Itoa(i)] = objectForm(child)
+			}
+		}
+		return m
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = objectForm(child)
+		}
+	}
+	return value
+}
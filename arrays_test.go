@@ -0,0 +1,102 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	for _, data := range []string{`["a",null,"c"]`, `{"0":"a","2":"c"}`} {
+		var l List[string]
+		assert.NoError(t, json.Unmarshal([]byte(data), &l))
+		assert.Equal(t, List[string]{"a", "", "c"}, l)
+	}
+	var l List[int]
+	assert.NoError(t, json.Unmarshal([]byte(`null`), &l))
+	assert.Nil(t, l)
+	assert.Error(t, json.Unmarshal([]byte(`{"a":1}`), &l))
+	assert.Error(t, json.Unmarshal([]byte(`12`), &l))
+	assert.NoError(t, json.Unmarshal([]byte(`{"1":1,"3":3}`), &l))
+	assert.Equal(t, List[int]{0, 1, 0, 3}, l)
+	assert.Error(t, json.Unmarshal([]byte(`{"1000000000":1}`), &l))
+	assert.Error(t, json.Unmarshal([]byte(`{"9223372036854775807":1}`), &l))
+	assert.Error(t, json.Unmarshal([]byte(`{"99999999999999999999":1}`), &l))
+}
+
+func TestIntMap(t *testing.T) {
+	for _, data := range []string{`[null,1,2]`, `{"1":1,"2":2}`} {
+		var m IntMap[int]
+		assert.NoError(t, json.Unmarshal([]byte(data), &m))
+		assert.Equal(t, IntMap[int]{1: 1, 2: 2}, m)
+	}
+	var m IntMap[int]
+	assert.Error(t, json.Unmarshal([]byte(`{"x":1}`), &m))
+}
+
+func TestStringMap(t *testing.T) {
+	var v struct {
+		Posts StringMap[tagPost] `json:"posts"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"posts":[{"title":"A"},null,{"title":"C"}]}`), &v))
+	assert.Equal(t, 2, len(v.Posts))
+	assert.Equal(t, "A", v.Posts["0"].Title)
+	assert.Equal(t, "2", v.Posts["2"].ID)
+}
+
+func TestArraysAsObjects(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+		w.Write([]byte("null"))
+	}))
+	defer server.Close()
+	ref := NewReference(server.URL).Ref("lists")
+
+	value := map[string]interface{}{"a": []interface{}{"x", nil, "z"}, "n": 12345678901234567}
+	assert.NoError(t, ref.Set(value))
+	assert.Equal(t, "{\"a\":[\"x\",null,\"z\"],\"n\":12345678901234567}", body)
+	assert.NoError(t, ref.ArraysAsObjects(true).Set(value))
+	assert.Equal(t, "{\"a\":{\"0\":\"x\",\"2\":\"z\"},\"n\":12345678901234567}", body)
+	assert.NoError(t, ref.ArraysAsObjects(true).Update(map[string][]int{"b": {1}}))
+	assert.Equal(t, "{\"b\":{\"0\":1}}", body)
+}
+
+func TestListsWithCodec(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"list":[1,9007199254740993],"ints":{"2":3},"strings":[null,4]}`))
+	}))
+	defer server.Close()
+	var v struct {
+		List    List[interface{}]      `json:"list"`
+		Ints    IntMap[interface{}]    `json:"ints"`
+		Strings StringMap[interface{}] `json:"strings"`
+	}
+	assert.NoError(t, NewReference(server.URL).UseNumber().Value(&v))
+	assert.Equal(t, List[interface{}]{json.Number("1"), json.Number("9007199254740993")}, v.List)
+	assert.Equal(t, IntMap[interface{}]{2: json.Number("3")}, v.Ints)
+	assert.Equal(t, StringMap[interface{}]{"1": json.Number("4")}, v.Strings)
+
+	var l List[interface{}]
+	assert.NoError(t, unmarshalValue(JSONCodec{UseNumber: true}, []byte(`[5]`), &l, ""))
+	assert.Equal(t, List[interface{}]{json.Number("5")}, l)
+}
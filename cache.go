@@ -0,0 +1,191 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReadCache merges the concurrent identical reads (same path, query and credentials)
+// into a single request and keeps the results for a short time. The entries are
+// invalidated by the writes made through the references using the cache. The
+// writes made by other clients are not seen until the entries expire. A cache is
+// safe for concurrent use and is shared by all the references derived from the
+// reference on which it is set.
+type ReadCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*cacheEntry
+	calls   map[string]*cacheCall
+	gen     uint64 // incremented by each invalidation
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	path    string
+	raw     json.RawMessage
+	expires time.Time
+}
+
+type cacheCall struct {
+	done     chan struct{}
+	raw      json.RawMessage
+	attempts int // number of times the request was sent
+	err      error
+	waiters  int                // readers waiting for the result
+	cancel   context.CancelFunc // cancels the request when no reader is waiting
+}
+
+// NewReadCache returns a cache that keeps the results for ttl. If ttl is 0, the
+// results are not kept, but the concurrent reads are still merged.
+func NewReadCache(ttl time.Duration) *ReadCache {
+	return &ReadCache{
+		ttl:     ttl,
+		entries: map[string]*cacheEntry{},
+		calls:   map[string]*cacheCall{},
+		now:     time.Now,
+	}
+}
+
+// WithReadCache returns a reference whose reads (Value) go through c. The reads
+// don't update the Attempts field of the reference, since they may be shared with
+// other readers; the number of attempts is set in the Operation seen by the interceptors.
+func (r *Reference) WithReadCache(c *ReadCache) *Reference {
+	result := *r
+	result.readCache = c
+	return &result
+}
+
+// related returns true if one of the paths contains the other.
+func related(a, b string) bool {
+	a, b = strings.TrimSuffix(a, "/")+"/", strings.TrimSuffix(b, "/")+"/"
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// Invalidate removes the entries for the locations containing or contained in path.
+func (c *ReadCache) Invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for key, entry := range c.entries {
+		if related(entry.path, path) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Clear removes all the entries.
+func (c *ReadCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.entries = map[string]*cacheEntry{}
+}
+
+// cacheKey returns the key of the read of an operation. The host and the query
+// (which holds the namespace of the emulator) separate the databases sharing a cache.
+func (r *Reference) cacheKey(op *Operation) string {
+	key := r.url.Host + op.Path + "?" + op.Query.Encode()
+	if r.auth != nil {
+		key += "&" + r.auth.ParamName() + "=" + r.auth.String()
+	}
+	if r.authOverride != nil {
+		key += "&auth_variable_override=" + *r.authOverride
+	}
+	return key
+}
+
+// read returns the cached result for key or calls fetch, merging the concurrent calls.
+// It also returns the number of times the request was sent (0 for a cached result).
+// The merged request is not canceled by the context of the reader that started it,
+// but only when none of the readers is waiting for the result.
+func (c *ReadCache) read(ctx context.Context, key, path string, fetch func(ctx context.Context) (json.RawMessage, int, error)) (json.RawMessage, int, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok {
+		if c.now().Before(entry.expires) {
+			c.mu.Unlock()
+			return entry.raw, 0, nil
+		}
+		delete(c.entries, key)
+	}
+	call, ok := c.calls[key]
+	if !ok {
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &cacheCall{done: make(chan struct{}), cancel: cancel}
+		c.calls[key] = call
+		gen := c.gen
+		go func() {
+			raw, attempts, err := fetch(fetchCtx)
+			cancel()
+			c.mu.Lock()
+			call.raw, call.attempts, call.err = raw, attempts, err
+			if c.calls[key] == call {
+				delete(c.calls, key)
+			}
+			if err == nil && c.ttl > 0 && gen == c.gen {
+				c.entries[key] = &cacheEntry{path: path, raw: raw, expires: c.now().Add(c.ttl)}
+			}
+			c.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	c.mu.Unlock()
+	select {
+	case <-call.done:
+		return call.raw, call.attempts, call.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			if c.calls[key] == call {
+				delete(c.calls, key) // the next reader starts a new request
+			}
+		}
+		c.mu.Unlock()
+		return nil, 0, ctx.Err()
+	}
+}
+
+// executeCached executes an operation on a reference with a read cache.
+func (r *Reference) executeCached(ctx context.Context, op *Operation) error {
+	if op.Method != "GET" || op.Name != OpGet || op.keepBody {
+		err := r.executeRequest(ctx, op)
+		if err == nil && op.Method != "GET" {
+			r.readCache.Invalidate(op.Path)
+		}
+		return err
+	}
+	op.shared = true
+	target, fetch := *r, *op
+	raw, attempts, err := r.readCache.read(ctx, r.cacheKey(op), op.Path, func(ctx context.Context) (json.RawMessage, int, error) {
+		var raw json.RawMessage
+		fetch.Result = &raw
+		err := target.executeRequest(ctx, &fetch)
+		return raw, fetch.Attempts, err
+	})
+	op.Attempts = attempts
+	if err != nil {
+		op.Status = errorStatus(err)
+		return err
+	}
+	op.Status = 200
+	return op.SetResult(raw)
+}
@@ -0,0 +1,206 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type cacheServer struct {
+	mu    sync.Mutex
+	gets  int
+	value string
+	delay time.Duration
+}
+
+func (s *cacheServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "GET" {
+		time.Sleep(s.delay)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Method == "GET" {
+		s.gets++
+	}
+	w.Write([]byte(s.value))
+}
+
+func (s *cacheServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets
+}
+
+func TestReadCacheCoalescing(t *testing.T) {
+	backend := &cacheServer{value: `{"name":"Ada"}`, delay: 20 * time.Millisecond}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+	ref := NewReference(server.URL).WithReadCache(NewReadCache(0)).Ref("users/ada")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v map[string]string
+			assert.NoError(t, ref.Value(&v))
+			assert.Equal(t, "Ada", v["name"])
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, backend.count())
+
+	var v interface{}
+	assert.NoError(t, ref.Value(&v)) // no TTL, so the result is not kept
+	assert.Equal(t, 2, backend.count())
+}
+
+func TestReadCacheAttempts(t *testing.T) {
+	var mu sync.Mutex
+	gets := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if gets++; gets == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`"Ada"`))
+	}))
+	defer server.Close()
+	var attempts []int
+	ref := NewReference(server.URL).
+		WithRetryPolicy(&RetryPolicy{BackOff: fastBackOff(), MaxAttempts: 3}).
+		WithReadCache(NewReadCache(0)).
+		Intercept(func(ctx context.Context, op *Operation, next Invoker) error {
+			err := next(ctx, op)
+			mu.Lock()
+			attempts = append(attempts, op.Attempts)
+			mu.Unlock()
+			return err
+		}).
+		Ref("users/ada")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var name string
+			assert.NoError(t, ref.Value(&name))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, gets)
+	assert.Equal(t, []int{2, 2, 2, 2, 2}, attempts)
+	assert.Equal(t, 0, ref.Attempts) // the shared reference is not modified
+}
+
+func TestReadCacheTTL(t *testing.T) {
+	backend := &cacheServer{value: `1`}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+	cache := NewReadCache(time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	db := NewReference(server.URL).WithReadCache(cache)
+	users := db.Ref("users")
+
+	var v interface{}
+	assert.NoError(t, users.Value(&v))
+	assert.NoError(t, users.Value(&v))
+	assert.Equal(t, 1, backend.count())
+
+	assert.NoError(t, users.OrderByKey().Value(&v)) // different query
+	assert.Equal(t, 2, backend.count())
+
+	assert.NoError(t, db.Ref("posts").Set(1)) // unrelated write
+	assert.NoError(t, users.Value(&v))
+	assert.Equal(t, 2, backend.count())
+
+	assert.NoError(t, db.Ref("users/ada/name").Set("Ada")) // write below the cached location
+	assert.NoError(t, users.Value(&v))
+	assert.NoError(t, users.OrderByKey().Value(&v))
+	assert.Equal(t, 4, backend.count())
+
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, users.Value(&v))
+	assert.Equal(t, 5, backend.count())
+
+	cache.Clear()
+	assert.NoError(t, users.Value(&v))
+	assert.Equal(t, 6, backend.count())
+}
+
+func TestReadCacheDatabases(t *testing.T) {
+	first := httptest.NewServer(&cacheServer{value: `"first"`})
+	defer first.Close()
+	second := httptest.NewServer(&cacheServer{value: `"second"`})
+	defer second.Close()
+	cache := NewReadCache(time.Minute)
+
+	c, err := NewShardedClient(Config{ReadCache: cache}, PrefixRouter(map[string]int{"b": 1}, 0),
+		Shard{URL: first.URL}, Shard{URL: second.URL})
+	assert.NoError(t, err)
+	defer c.Close()
+	var a, b string
+	assert.NoError(t, c.Shards()[0].Ref("users").Value(&a))
+	assert.NoError(t, c.Shards()[1].Ref("users").Value(&b))
+	assert.Equal(t, "first", a)
+	assert.Equal(t, "second", b)
+
+	db := NewReference(first.URL).WithReadCache(cache)
+	assert.NoError(t, db.Ref("x").withParam("ns", "one").Value(&a))
+	assert.NoError(t, db.Ref("x").withParam("ns", "two").Value(&b))
+	assert.Equal(t, 4, len(cache.entries)) // two per database
+}
+
+func TestReadCacheCanceledReader(t *testing.T) {
+	backend := &cacheServer{value: `"Ada"`, delay: 50 * time.Millisecond}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+	ref := NewReference(server.URL).WithReadCache(NewReadCache(0)).Ref("users/ada")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		var v string
+		first <- ref.WithContext(ctx).Value(&v)
+	}()
+	time.Sleep(10 * time.Millisecond) // the first reader starts the request
+	second := make(chan error, 1)
+	var v string
+	go func() { second <- ref.Value(&v) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.Error(t, <-first)
+	assert.NoError(t, <-second)
+	assert.Equal(t, "Ada", v)
+	assert.Equal(t, 1, backend.count())
+
+	// the request is canceled when no reader is waiting
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, ref.WithContext(ctx).Value(&v))
+	assert.NoError(t, ref.Value(&v))
+}
@@ -0,0 +1,354 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.google.com/docs/database/usage/limits
+
+package firebasedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxWriteSize is the maximum size (in bytes) of a single write request
+// accepted by the REST API.
+const MaxWriteSize = 256 << 20
+
+// WriteChunk is one of the requests of a chunked write. Method is "PUT" or
+// "PATCH". The keys of Values are paths relative to the reference of the
+// writer. For a PUT, the values are expanded into a single object.
+type WriteChunk struct {
+	Method string
+	Values map[string]json.RawMessage
+}
+
+// ChunkedWriter writes values that are too large for a single request. The
+// value is split along the child boundaries into several requests that are
+// smaller than the limits. The writes are not atomic: if a request fails,
+// the writer returns a *PartialWriteError that can be used to retry the
+// remaining requests.
+type ChunkedWriter struct {
+	ref      *Reference
+	maxBytes int
+	maxNodes int
+}
+
+// PartialWriteError is returned by a ChunkedWriter when one of the requests
+// fails. Written is the number of chunks that were written and Remaining
+// holds the chunks that still need to be written (starting with the one that
+// failed).
+type PartialWriteError struct {
+	Err       error
+	Written   int
+	Remaining []WriteChunk
+	writer    *ChunkedWriter
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("partial write (%v chunks written, %v remaining): %v", e.Written, len(e.Remaining), e.Err)
+}
+
+// Retry writes the remaining chunks. It returns a new *PartialWriteError if
+// a request fails again.
+func (e *PartialWriteError) Retry() error {
+	return e.writer.write(e.Remaining)
+}
+
+// Chunked returns a writer that splits the writes into requests of at most
+// maxBytes bytes. If maxBytes <= 0, then MaxWriteSize is used.
+func (r *Reference) Chunked(maxBytes int) *ChunkedWriter {
+	if maxBytes <= 0 {
+		maxBytes = MaxWriteSize
+	}
+	return &ChunkedWriter{ref: r, maxBytes: maxBytes}
+}
+
+// MaxNodes limits the number of leaf nodes written by each request. Zero means no limit.
+func (w *ChunkedWriter) MaxNodes(n int) *ChunkedWriter {
+	result := *w
+	result.maxNodes = n
+	return &result
+}
+
+// chunkEntry is a value to be written at a relative path.
+type chunkEntry struct {
+	path  string
+	raw   json.RawMessage
+	size  int
+	nodes int
+	last  bool // the entry ends its chunk (the next entries write below its path)
+}
+
+// countNodes returns the number of leaf nodes of a JSON value.
+func countNodes(raw json.RawMessage) int {
+	var v interface{}
+	if json.Unmarshal(raw, &v) != nil {
+		return 1
+	}
+	var count func(v interface{}) int
+	count = func(v interface{}) int {
+		n := 0
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for _, c := range t {
+				n += count(c)
+			}
+		case []interface{}:
+			for _, c := range t {
+				n += count(c)
+			}
+		default:
+			n = 1
+		}
+		return n
+	}
+	return count(v)
+}
+
+// newChunkEntry measures the size and the number of nodes of a value.
+func (w *ChunkedWriter) newChunkEntry(path string, raw json.RawMessage) chunkEntry {
+	e := chunkEntry{path: path, raw: raw, size: len(path) + len(raw) + 4}
+	if w.maxNodes > 0 {
+		e.nodes = countNodes(raw)
+	}
+	return e
+}
+
+// fits returns true if the entry can be written with a single request.
+func (w *ChunkedWriter) fits(size, nodes int) bool {
+	return size+2 <= w.maxBytes && (w.maxNodes <= 0 || nodes <= w.maxNodes)
+}
+
+// children splits a JSON object or array into its children.
+func children(raw json.RawMessage) (map[string]json.RawMessage, bool) {
+	var m map[string]json.RawMessage
+	if json.Unmarshal(raw, &m) == nil && m != nil {
+		return m, true
+	}
+	var a []json.RawMessage
+	if json.Unmarshal(raw, &a) == nil && a != nil {
+		m = map[string]json.RawMessage{}
+		for i, v := range a {
+			if string(v) != "null" {
+				m[strconv.Itoa(i)] = v
+			}
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// split splits an entry into entries that fit in a request.
+func (w *ChunkedWriter) split(e chunkEntry) ([]chunkEntry, error) {
+	if w.fits(e.size, e.nodes) {
+		return []chunkEntry{e}, nil
+	}
+	m, ok := children(e.raw)
+	if !ok || len(m) == 0 {
+		return nil, errors.New(fmt.Sprintf("The value at %q is too large and can't be split", e.path))
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Sort(byKey(keys))
+	var result []chunkEntry
+	for _, k := range keys {
+		path := k
+		if e.path != "" {
+			path = e.path + "/" + k
+		}
+		entries, err := w.split(w.newChunkEntry(path, m[k]))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, entries...)
+	}
+	return result, nil
+}
+
+// replacing returns the entries of a property of an update that was split. A PATCH
+// of the sub-paths would merge them into the existing node, so the first entry
+// replaces the property with as many entries as fit in a request (or removes it if
+// none fits) and the next requests add the other entries.
+func (w *ChunkedWriter) replacing(path string, entries []chunkEntry) ([]chunkEntry, error) {
+	n, size, nodes := 0, 0, 0
+	for n < len(entries) && w.fits(size+entries[n].size, nodes+entries[n].nodes) {
+		size += entries[n].size
+		nodes += entries[n].nodes
+		n++
+	}
+	for ; n > 0; n-- {
+		values := map[string]json.RawMessage{}
+		for _, e := range entries[:n] {
+			values[strings.TrimPrefix(e.path, path+"/")] = e.raw
+		}
+		raw, err := json.Marshal(expand(values))
+		if err != nil {
+			return nil, err
+		}
+		first := w.newChunkEntry(path, raw)
+		if w.fits(first.size, first.nodes) {
+			first.last = true
+			return append([]chunkEntry{first}, entries[n:]...), nil
+		}
+	}
+	remove := w.newChunkEntry(path, json.RawMessage("null"))
+	remove.last = true
+	return append([]chunkEntry{remove}, entries...), nil
+}
+
+// group groups the entries into chunks.
+func (w *ChunkedWriter) group(entries []chunkEntry, method string) []WriteChunk {
+	var result []WriteChunk
+	var current WriteChunk
+	size, nodes := 0, 0
+	for _, e := range entries {
+		if len(current.Values) > 0 && !w.fits(size+e.size, nodes+e.nodes) {
+			result = append(result, current)
+			current = WriteChunk{}
+			size, nodes = 0, 0
+		}
+		if current.Values == nil {
+			current = WriteChunk{Method: method, Values: map[string]json.RawMessage{}}
+			method = "PATCH" // only the first chunk of a Set is a PUT
+		}
+		current.Values[e.path] = e.raw
+		size += e.size
+		nodes += e.nodes
+		if e.last {
+			result = append(result, current)
+			current = WriteChunk{}
+			size, nodes = 0, 0
+		}
+	}
+	if len(current.Values) > 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+// plan computes the chunks needed to write value.
+func (w *ChunkedWriter) plan(value interface{}, set bool) ([]WriteChunk, error) {
+	reader, err := w.ref.body(value, !set)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error reading body: %v", err))
+	}
+	raw, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimSpace(raw)
+	if set {
+		if w.fits(len(raw), w.newChunkEntry("", raw).nodes) {
+			return []WriteChunk{{Method: "PUT", Values: map[string]json.RawMessage{"": raw}}}, nil
+		}
+		entries, err := w.split(w.newChunkEntry("", raw))
+		if err != nil {
+			return nil, err
+		}
+		return w.group(entries, "PUT"), nil
+	}
+	m, ok := children(raw)
+	if !ok {
+		return nil, errors.New("The value of an update must be an object")
+	}
+	var entries []chunkEntry
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		path := strings.Trim(k, "/")
+		e, err := w.split(w.newChunkEntry(path, m[k]))
+		if err != nil {
+			return nil, err
+		}
+		if len(e) > 1 || e[0].path != path {
+			if e, err = w.replacing(path, e); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e...)
+	}
+	return w.group(entries, "PATCH"), nil
+}
+
+// expand converts the relative paths of a PUT chunk into nested objects.
+func expand(values map[string]json.RawMessage) interface{} {
+	if raw, ok := values[""]; ok {
+		return raw
+	}
+	root := map[string]interface{}{}
+	for path, raw := range values {
+		node := root
+		parts := strings.Split(path, "/")
+		for _, p := range parts[:len(parts)-1] {
+			child, ok := node[p].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[p] = child
+			}
+			node = child
+		}
+		node[parts[len(parts)-1]] = raw
+	}
+	return root
+}
+
+// write executes the chunks.
+func (w *ChunkedWriter) write(chunks []WriteChunk) error {
+	for i, chunk := range chunks {
+		var err error
+		if chunk.Method == "PUT" {
+			err = w.ref.Set(expand(chunk.Values))
+		} else {
+			err = w.ref.Update(chunk.Values)
+		}
+		if err != nil {
+			return &PartialWriteError{Err: err, Written: i, Remaining: chunks[i:], writer: w}
+		}
+	}
+	return nil
+}
+
+// Set writes value at the location of the reference, as Reference.Set does. If the
+// value is too large, the first request replaces the location with a part of the value
+// and the other parts are added with PATCH requests.
+func (w *ChunkedWriter) Set(value interface{}) error {
+	chunks, err := w.plan(value, true)
+	if err != nil {
+		return err
+	}
+	return w.write(chunks)
+}
+
+// Update updates the children of the location, as Reference.Update does. The
+// properties are written with as few PATCH requests as possible. A property that
+// is too large is replaced by the first request and completed by the next ones.
+func (w *ChunkedWriter) Update(value interface{}) error {
+	chunks, err := w.plan(value, false)
+	if err != nil {
+		return err
+	}
+	return w.write(chunks)
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeServer is an in-memory server for PUT and PATCH requests.
+type writeServer struct {
+	mu       sync.Mutex
+	data     interface{}
+	requests []int // size of the requests
+	failAt   int   // fails the nth request (starting at 1) if > 0
+}
+
+func (s *writeServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := ioutil.ReadAll(req.Body)
+	s.requests = append(s.requests, len(body))
+	if len(s.requests) == s.failAt {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var value interface{}
+	json.Unmarshal(body, &value)
+	path := splitPath(strings.TrimSuffix(req.URL.Path, ".json"))
+	switch req.Method {
+	case "PUT":
+		v, _ := normalizeData(value)
+		s.data = setNode(s.data, path, v)
+	case "PATCH":
+		for k := range value.(map[string]interface{}) {
+			for other := range value.(map[string]interface{}) {
+				if strings.HasPrefix(other, strings.TrimSuffix(k, "/")+"/") {
+					w.WriteHeader(http.StatusBadRequest) // like the server, reject overlapping paths
+					return
+				}
+			}
+		}
+		for k, child := range value.(map[string]interface{}) {
+			v, _ := normalizeData(child)
+			s.data = setNode(s.data, append(append([]string{}, path...), splitPath(k)...), v)
+		}
+	}
+	w.Write([]byte("null"))
+}
+
+func TestChunkedWriter(t *testing.T) {
+	s := &writeServer{}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	ref := NewReference(server.URL).Ref("/big")
+
+	value := map[string]interface{}{}
+	for i := 0; i < 20; i++ {
+		child := map[string]interface{}{}
+		for j := 0; j < 10; j++ {
+			child[fmt.Sprintf("field-%02d", j)] = strings.Repeat("x", 40)
+		}
+		value[fmt.Sprintf("child-%02d", i)] = child
+	}
+	expected, _ := normalizeData(value)
+
+	s.data = map[string]interface{}{"big": map[string]interface{}{"old": true}, "other": 1.0}
+	assert.NoError(t, ref.Chunked(2000).Set(value))
+	assert.Equal(t, expected, getNode(s.data, []string{"big"}))
+	assert.Equal(t, 1.0, getNode(s.data, []string{"other"}))
+	assert.True(t, len(s.requests) > 4)
+	for _, size := range s.requests {
+		assert.True(t, size <= 2000, "request of %v bytes", size)
+	}
+
+	// a child larger than the limit is split
+	s.requests = nil
+	assert.NoError(t, ref.Chunked(1000).MaxNodes(5).Update(map[string]interface{}{"child-03": value["child-00"]}))
+	assert.Equal(t, expected, getNode(s.data, []string{"big"}))
+	assert.Len(t, s.requests, 2)
+
+	// a single value can't be split
+	err := ref.Chunked(10).Set(strings.Repeat("x", 100))
+	assert.Error(t, err)
+
+	// partial failure
+	s.data, s.requests, s.failAt = nil, nil, 3
+	err = ref.Chunked(2000).Set(value)
+	partial, ok := err.(*PartialWriteError)
+	assert.True(t, ok)
+	assert.Equal(t, 2, partial.Written)
+	assert.NotEqual(t, expected, getNode(s.data, []string{"big"}))
+	assert.NoError(t, partial.Retry())
+	assert.Equal(t, expected, getNode(s.data, []string{"big"}))
+}
+
+func TestChunkedUpdateReplaces(t *testing.T) {
+	s := &writeServer{}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	ref := NewReference(server.URL).Ref("/big")
+
+	child := map[string]interface{}{}
+	for j := 0; j < 10; j++ {
+		child[fmt.Sprintf("field-%02d", j)] = map[string]interface{}{"a": strings.Repeat("x", 40), "b": 1}
+	}
+	expected, _ := normalizeData(child)
+	stale := func() interface{} {
+		return map[string]interface{}{"big": map[string]interface{}{
+			"child": map[string]interface{}{"stale": true, "field-00": map[string]interface{}{"old": true}},
+			"other": 1.0,
+		}}
+	}
+
+	s.data = stale()
+	assert.NoError(t, ref.Update(map[string]interface{}{"child": child}))
+	assert.Equal(t, expected, getNode(s.data, []string{"big", "child"}))
+
+	s.data, s.requests = stale(), nil
+	assert.NoError(t, ref.Chunked(300).Update(map[string]interface{}{"child": child}))
+	assert.Equal(t, expected, getNode(s.data, []string{"big", "child"}))
+	assert.Equal(t, 1.0, getNode(s.data, []string{"big", "other"}))
+	assert.True(t, len(s.requests) > 2)
+	for _, size := range s.requests {
+		assert.True(t, size <= 300, "request of %v bytes", size)
+	}
+
+	// the first part doesn't fit once nested: the child is removed first
+	large := map[string]interface{}{"a": strings.Repeat("x", 83), "b": strings.Repeat("y", 83)}
+	s.data, s.requests = stale(), nil
+	assert.NoError(t, ref.Chunked(100).Update(map[string]interface{}{"child": large}))
+	expected, _ = normalizeData(large)
+	assert.Equal(t, expected, getNode(s.data, []string{"big", "child"}))
+	assert.Len(t, s.requests, 3)
+}
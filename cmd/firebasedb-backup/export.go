@@ -0,0 +1,208 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/BlueMasters/firebasedb"
+)
+
+// exportState is the progress of an export.
+type exportState struct {
+	Cursor string `json:"cursor"` // key of the last exported child
+	Count  int    `json:"count"`  // number of exported children
+	Chunks int    `json:"chunks"` // number of tar entries
+	Offset int64  `json:"offset"` // size of the output file
+}
+
+// countingWriter counts the bytes written to the output file.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// exporter writes the lines of an export and saves the progress after each chunk.
+type exporter struct {
+	format    string
+	file      *os.File
+	out       *countingWriter
+	buf       *bufio.Writer
+	tar       *tar.Writer
+	chunk     bytes.Buffer
+	lines     int
+	stateFile string
+	state     exportState
+}
+
+func (e *exporter) write(l line) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	e.chunk.Write(b)
+	e.chunk.WriteByte('\n')
+	e.lines++
+	e.state.Cursor = l.Key
+	e.state.Count++
+	return nil
+}
+
+// commit writes the current chunk to the file and saves the progress.
+func (e *exporter) commit() error {
+	if e.lines == 0 {
+		return nil
+	}
+	if e.format == "tar" {
+		e.state.Chunks++
+		err := e.tar.WriteHeader(&tar.Header{
+			Name:    fmt.Sprintf("chunk-%06d.ndjson", e.state.Chunks),
+			Mode:    0644,
+			Size:    int64(e.chunk.Len()),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = e.tar.Write(e.chunk.Bytes()); err != nil {
+			return err
+		}
+		if err = e.tar.Flush(); err != nil {
+			return err
+		}
+	} else if _, err := e.buf.Write(e.chunk.Bytes()); err != nil {
+		return err
+	}
+	if err := e.buf.Flush(); err != nil {
+		return err
+	}
+	if err := e.file.Sync(); err != nil {
+		return err
+	}
+	e.chunk.Reset()
+	e.lines = 0
+	e.state.Offset = e.out.n
+	log.Printf("exported %v children (last key %q)", e.state.Count, e.state.Cursor)
+	return writeState(e.stateFile, &e.state)
+}
+
+func (e *exporter) close() error {
+	if e.tar != nil {
+		if err := e.tar.Close(); err != nil {
+			return err
+		}
+	}
+	if err := e.buf.Flush(); err != nil {
+		return err
+	}
+	return e.file.Close()
+}
+
+func export(args []string) error {
+	var opts options
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	opts.register(fs)
+	out := fs.String("out", "", "output file")
+	pageSize := fs.Int("page-size", firebasedb.DefaultPageSize, "number of children per request")
+	concurrency := fs.Int("concurrency", 1, "number of parallel requests")
+	chunkSize := fs.Int("chunk", 10000, "number of children per chunk (tar entry or progress checkpoint)")
+	fs.Parse(args)
+	ref, err := opts.reference()
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("missing -out")
+	}
+
+	e := &exporter{format: opts.format, stateFile: *out + ".state"}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.resume {
+		if err = readState(e.stateFile, &e.state); err != nil {
+			return fmt.Errorf("can't resume: %v", err)
+		}
+		flags = os.O_WRONLY
+	}
+	if e.file, err = os.OpenFile(*out, flags, 0644); err != nil {
+		return err
+	}
+	if opts.resume {
+		// drop what was written after the last checkpoint
+		if err = e.file.Truncate(e.state.Offset); err != nil {
+			return err
+		}
+		if _, err = e.file.Seek(e.state.Offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	e.out = &countingWriter{w: e.file, n: e.state.Offset}
+	e.buf = bufio.NewWriter(e.out)
+	if e.format == "tar" {
+		e.tar = tar.NewWriter(e.buf)
+	}
+
+	it := ref.Export().Iterate(*pageSize).Concurrency(*concurrency)
+	if opts.resume && e.state.Count > 0 {
+		it = it.StartAfter(e.state.Cursor)
+	}
+	defer it.Close()
+	for it.Next() {
+		if err = e.write(line{Key: it.Key(), Value: it.Raw()}); err != nil {
+			return err
+		}
+		if e.lines >= *chunkSize {
+			if err = e.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	if err = it.Err(); err != nil {
+		e.commit()
+		return fmt.Errorf("export interrupted, use -resume to continue: %v", err)
+	}
+	if e.state.Count == 0 {
+		// the location has no children: export the value itself
+		var value json.RawMessage
+		if err = ref.Export().Value(&value); err != nil {
+			return err
+		}
+		if string(value) != "null" {
+			e.write(line{Key: "", Value: value})
+		}
+	}
+	if err = e.commit(); err != nil {
+		return err
+	}
+	if err = e.close(); err != nil {
+		return err
+	}
+	log.Printf("export finished: %v children", e.state.Count)
+	return os.Remove(e.stateFile)
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command firebasedb-backup exports a Firebase Realtime Database (or a subtree)
+// to a file and restores it.
+//
+// Usage:
+//
+//	firebasedb-backup export -url https://<db>.firebaseio.com -path /users -out users.ndjson
+//	firebasedb-backup restore -url https://<db>.firebaseio.com -path /users -in users.ndjson
+//
+// The export contains one line per child of the exported location. Each line is
+// a JSON object with the key of the child and its value in the export format
+// (with the priorities). With -format tar, the lines are grouped in chunks stored
+// as the entries of a tar archive.
+//
+// The progress is saved next to the output (or input) file, so that an interrupted
+// export or restore can be continued with -resume.
+//
+// The database secret is read from the -secret flag or from the FIREBASE_DB_SECRET
+// environment variable. If FIREBASE_DATABASE_EMULATOR_HOST is set, the data is
+// exported from (or restored to) the emulator.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/BlueMasters/firebasedb"
+)
+
+// line is a line of an export.
+type line struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// options are the flags common to export and restore.
+type options struct {
+	url    string
+	path   string
+	secret string
+	format string
+	resume bool
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.url, "url", "", "URL of the database")
+	fs.StringVar(&o.path, "path", "/", "location to export or to restore")
+	fs.StringVar(&o.secret, "secret", os.Getenv("FIREBASE_DB_SECRET"), "database secret")
+	fs.StringVar(&o.format, "format", "ndjson", "format of the file: ndjson or tar")
+	fs.BoolVar(&o.resume, "resume", false, "resume an interrupted operation")
+}
+
+// reference returns the reference for the options.
+func (o *options) reference() (*firebasedb.Reference, error) {
+	if o.url == "" {
+		return nil, fmt.Errorf("missing -url")
+	}
+	if o.format != "ndjson" && o.format != "tar" {
+		return nil, fmt.Errorf("invalid format %q", o.format)
+	}
+	db := firebasedb.NewEmulatorAwareReference(o.url)
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	if o.secret != "" {
+		db = db.Auth(firebasedb.Secret{Token: o.secret})
+	}
+	return db.Ref(o.path), nil
+}
+
+// readState reads the progress of an interrupted operation.
+func readState(filename string, state interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, state)
+}
+
+// writeState saves the progress of an operation. The file is replaced atomically.
+func writeState(filename string, state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: firebasedb-backup export|restore [flags]")
+	fmt.Fprintln(os.Stderr, "run 'firebasedb-backup export -h' or 'firebasedb-backup restore -h' for the flags")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = export(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryDB is an in-memory database implementing the requests used by export and restore.
+type memoryDB struct {
+	mu   sync.Mutex
+	data interface{}
+}
+
+func splitPath(path string) []string {
+	var result []string
+	for _, p := range strings.Split(path, "/") {
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (db *memoryDB) get(path []string) interface{} {
+	node := db.data
+	for _, p := range path {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[p]
+	}
+	return node
+}
+
+func setNode(node interface{}, path []string, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{}
+	}
+	child := setNode(m[path[0]], path[1:], value)
+	if child == nil {
+		delete(m, path[0])
+	} else {
+		m[path[0]] = child
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+func (db *memoryDB) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	path := splitPath(strings.TrimSuffix(req.URL.Path, ".json"))
+	q := req.URL.Query()
+	var body interface{}
+	json.NewDecoder(req.Body).Decode(&body)
+	switch req.Method {
+	case "GET":
+		node := db.get(path)
+		m, isMap := node.(map[string]interface{})
+		if q.Get("shallow") == "true" {
+			if q.Get("format") != "" || q.Get("orderBy") != "" {
+				http.Error(w, `{"error":"shallow can't be used with other parameters"}`, http.StatusBadRequest)
+				return
+			}
+			if isMap {
+				keys := map[string]bool{}
+				for k := range m {
+					keys[k] = true
+				}
+				node = keys
+			}
+		} else if q.Get("orderBy") == `"$key"` && isMap {
+			var keys []string
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			limit, _ := strconv.Atoi(q.Get("limitToFirst"))
+			page := map[string]interface{}{}
+			for _, k := range keys {
+				if s := q.Get("startAt"); s != "" && k < strings.Trim(s, `"`) {
+					continue
+				}
+				if e := q.Get("endAt"); e != "" && k > strings.Trim(e, `"`) {
+					continue
+				}
+				if limit > 0 && len(page) == limit {
+					break
+				}
+				page[k] = m[k]
+			}
+			node = page
+		}
+		json.NewEncoder(w).Encode(node)
+	case "PUT":
+		db.data = setNode(db.data, path, body)
+		json.NewEncoder(w).Encode(body)
+	case "PATCH":
+		for k, v := range body.(map[string]interface{}) {
+			db.data = setNode(db.data, append(append([]string{}, path...), splitPath(k)...), v)
+		}
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func decodeJSON(t *testing.T, s string) interface{} {
+	var v interface{}
+	assert.NoError(t, json.Unmarshal([]byte(s), &v))
+	return v
+}
+
+func TestExportRestore(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+
+	users := map[string]interface{}{}
+	for i := 0; i < 30; i++ {
+		posts := map[string]interface{}{}
+		for j := 0; j < 8; j++ {
+			posts[fmt.Sprintf("post-%02d", j)] = map[string]interface{}{"text": strings.Repeat("x", 50), "likes": float64(j)}
+		}
+		users[fmt.Sprintf("user-%03d", i)] = map[string]interface{}{"name": fmt.Sprintf("User %v", i), "posts": posts}
+	}
+	source := &memoryDB{data: map[string]interface{}{"users": users}}
+	sourceServer := httptest.NewServer(source)
+	defer sourceServer.Close()
+
+	for format, concurrency := range map[string]string{"ndjson": "1", "tar": "3"} {
+		file := filepath.Join(t.TempDir(), "users."+format)
+		assert.NoError(t, export([]string{"-url", sourceServer.URL, "-path", "/users", "-out", file,
+			"-format", format, "-page-size", "7", "-chunk", "5", "-concurrency", concurrency}))
+
+		// the target has stale data in the restored children
+		target := &memoryDB{data: decodeJSON(t, `{"users": {
+			"user-003": {"name": "Old", "stale": true, "posts": {"post-00": {"old": true}, "post-99": {}}},
+			"user-010": "replaced"
+		}, "other": 1}`)}
+		targetServer := httptest.NewServer(target)
+		assert.NoError(t, restore([]string{"-url", targetServer.URL, "-path", "/users", "-in", file,
+			"-format", format, "-max-size", "600"}))
+		targetServer.Close()
+		assert.Equal(t, users, target.get([]string{"users"}), format)
+		assert.Equal(t, float64(1), target.get([]string{"other"}), format)
+	}
+}
+
+func TestRestoreValue(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+
+	file := filepath.Join(t.TempDir(), "value.ndjson")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"key":"","value":"hello"}`+"\n"), 0644))
+	target := &memoryDB{data: decodeJSON(t, `{"greeting": {"old": true}}`)}
+	server := httptest.NewServer(target)
+	defer server.Close()
+	assert.NoError(t, restore([]string{"-url", server.URL, "-path", "/greeting", "-in", file}))
+	assert.Equal(t, "hello", target.get([]string{"greeting"}))
+}
@@ -0,0 +1,159 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/BlueMasters/firebasedb"
+)
+
+// restoreState is the progress of a restore.
+type restoreState struct {
+	Lines int `json:"lines"` // number of restored lines
+}
+
+// readLines calls fn for each line of the export.
+func readLines(r io.Reader, format string, fn func(l line) error) error {
+	if format == "tar" {
+		tr := tar.NewReader(r)
+		for {
+			if _, err := tr.Next(); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := readLines(tr, "ndjson", fn); err != nil {
+				return err
+			}
+		}
+	}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var l line
+		if err := dec.Decode(&l); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+}
+
+// restorer groups the lines in batches written with a single PATCH request when possible.
+type restorer struct {
+	ref       *firebasedb.Reference
+	maxSize   int
+	batch     map[string]json.RawMessage
+	size      int
+	lines     int // lines in the batch
+	stateFile string
+	state     restoreState
+}
+
+func (r *restorer) add(l line) error {
+	if l.Key == "" { // the exported location has no children
+		r.lines++
+		return r.ref.Chunked(r.maxSize).Set(l.Value)
+	}
+	size := len(l.Key) + len(l.Value) + 4
+	if r.size+size > r.maxSize {
+		if err := r.flush(); err != nil {
+			return err
+		}
+	}
+	r.batch[l.Key] = l.Value
+	r.size += size
+	r.lines++
+	return nil
+}
+
+// flush writes the current batch and saves the progress.
+func (r *restorer) flush() error {
+	if r.lines == 0 {
+		return nil
+	}
+	if len(r.batch) > 0 {
+		// children larger than maxSize are split by the chunked writer
+		if err := r.ref.Silent().Chunked(r.maxSize).Update(r.batch); err != nil {
+			return err
+		}
+	}
+	r.state.Lines += r.lines
+	r.batch = map[string]json.RawMessage{}
+	r.size = 0
+	r.lines = 0
+	log.Printf("restored %v children", r.state.Lines)
+	return writeState(r.stateFile, &r.state)
+}
+
+func restore(args []string) error {
+	var opts options
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	opts.register(fs)
+	in := fs.String("in", "", "input file")
+	maxSize := fs.Int("max-size", 10<<20, "maximum size of a write request in bytes")
+	fs.Parse(args)
+	ref, err := opts.reference()
+	if err != nil {
+		return err
+	}
+	if *in == "" {
+		return fmt.Errorf("missing -in")
+	}
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := &restorer{
+		ref:       ref,
+		maxSize:   *maxSize,
+		batch:     map[string]json.RawMessage{},
+		stateFile: *in + ".restore-state",
+	}
+	skip := 0
+	if opts.resume {
+		if err = readState(r.stateFile, &r.state); err != nil {
+			return fmt.Errorf("can't resume: %v", err)
+		}
+		skip = r.state.Lines
+	}
+	err = readLines(file, opts.format, func(l line) error {
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		return r.add(l)
+	})
+	if err == nil {
+		err = r.flush()
+	}
+	if err != nil {
+		return fmt.Errorf("restore interrupted after %v children, use -resume to continue: %v", r.state.Lines, err)
+	}
+	log.Printf("restore finished: %v children", r.state.Lines)
+	return os.Remove(r.stateFile)
+}
@@ -0,0 +1,372 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command firebasedb is a command line client for the Firebase Realtime Database.
+//
+// Usage:
+//
+//	firebasedb [global flags] <command> [flags] <path> [value]
+//
+// The commands are:
+//
+//	get       read the value at path
+//	shallow   list the keys of the children of path
+//	set       write value at path
+//	update    update the children of path with the properties of value
+//	push      add value as a new child of path and print its key
+//	remove    delete the value at path
+//	query     read the children of path matching a query
+//	listen    print the events of path until interrupted
+//	rules     "rules get" prints the security rules, "rules set <file>" uploads them
+//
+// The values are JSON documents. Use "-" to read the value from the standard input.
+//
+// The global flags are:
+//
+//	-url            URL of the database (default $FIREBASE_DB_URL)
+//	-secret         database secret (default $FIREBASE_DB_SECRET)
+//	-access-token   OAuth2 access token (default $FIREBASE_DB_ACCESS_TOKEN)
+//	-debug          print the requests on the standard error
+//
+// If FIREBASE_DATABASE_EMULATOR_HOST is set, the requests are sent to the emulator
+// with the "owner" credentials (unless -secret or -access-token is given).
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/BlueMasters/firebasedb"
+)
+
+// accessToken is an Authenticator for OAuth2 access tokens (for example the
+// output of "gcloud auth print-access-token").
+type accessToken string
+
+func (t accessToken) String() string {
+	return string(t)
+}
+
+func (t accessToken) ParamName() string {
+	return "access_token"
+}
+
+func (t accessToken) Renew() error {
+	return errors.New("Can't renew an access token, please get a new one")
+}
+
+var (
+	dbUrl       = flag.String("url", os.Getenv("FIREBASE_DB_URL"), "URL of the database")
+	secret      = flag.String("secret", os.Getenv("FIREBASE_DB_SECRET"), "database secret")
+	token       = flag.String("access-token", os.Getenv("FIREBASE_DB_ACCESS_TOKEN"), "OAuth2 access token")
+	debug       = flag.Bool("debug", false, "print the requests on the standard error")
+	stdout      = os.Stdout
+	commandList = "get, shallow, set, update, push, remove, query, listen, rules"
+)
+
+// database returns the reference to the root of the database.
+func database() (*firebasedb.Reference, error) {
+	if *dbUrl == "" {
+		return nil, errors.New("missing -url (or FIREBASE_DB_URL)")
+	}
+	db := firebasedb.NewEmulatorAwareReference(*dbUrl)
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	if *secret != "" && *token != "" {
+		return nil, errors.New("use either -secret or -access-token")
+	}
+	if *secret != "" {
+		db = db.Auth(firebasedb.Secret{Token: *secret})
+	} else if *token != "" {
+		db = db.Auth(accessToken(*token))
+	}
+	if *debug {
+		db = db.Debug(os.Stderr)
+	}
+	return db, nil
+}
+
+// readValue returns the JSON value given on the command line or on the standard input.
+func readValue(arg string) (json.RawMessage, error) {
+	data := []byte(arg)
+	if arg == "-" {
+		var err error
+		if data, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return nil, err
+		}
+	}
+	if !json.Valid(data) {
+		return nil, errors.New("the value is not a valid JSON document")
+	}
+	return json.RawMessage(data), nil
+}
+
+// printJSON prints a JSON value with indentation.
+func printJSON(raw json.RawMessage) error {
+	var b bytes.Buffer
+	if err := json.Indent(&b, raw, "", "  "); err != nil {
+		return err
+	}
+	b.WriteByte('\n')
+	_, err := b.WriteTo(stdout)
+	return err
+}
+
+// queryValue converts a command line argument to a query value: numbers are
+// passed as numbers and everything else as a string. Use quotes to pass a number
+// as a string (for example '"42"').
+func queryValue(s string) interface{} {
+	if strings.HasPrefix(s, `"`) {
+		return strings.Trim(s, `"`)
+	}
+	if i, err := strconv.Atoi(s); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// keyValue converts a command line argument to a query value when ordering by
+// key: the keys are always strings, so numbers are passed as strings.
+func keyValue(s string) interface{} {
+	if v, ok := queryValue(s).(string); ok {
+		return v
+	}
+	return s
+}
+
+// command parses the flags of a command and checks the number of positional arguments.
+func command(fs *flag.FlagSet, args []string, n int, usage string) ([]string, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: firebasedb %v %v\n", fs.Name(), usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args(), nil
+}
+
+func get(db *firebasedb.Reference, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	export := fs.Bool("export", false, "include the priorities")
+	shallow := fs.Bool("shallow", false, "only list the keys of the children")
+	a, err := command(fs, args, 1, "[flags] <path>")
+	if err != nil {
+		return err
+	}
+	ref := db.Ref(a[0])
+	if *export {
+		ref = ref.Export()
+	}
+	if *shallow {
+		ref = ref.Shallow()
+	}
+	var value json.RawMessage
+	if err = ref.Value(&value); err != nil {
+		return err
+	}
+	return printJSON(value)
+}
+
+func write(db *firebasedb.Reference, name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	a, err := command(fs, args, 2, "<path> <json value>")
+	if err != nil {
+		return err
+	}
+	value, err := readValue(a[1])
+	if err != nil {
+		return err
+	}
+	ref := db.Ref(a[0])
+	switch name {
+	case "set":
+		return ref.Set(value)
+	case "update":
+		return ref.Update(value)
+	}
+	key, err := ref.Push(value)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, key)
+	return nil
+}
+
+func remove(db *firebasedb.Reference, args []string) error {
+	fs := flag.NewFlagSet("remove", flag.ExitOnError)
+	a, err := command(fs, args, 1, "<path>")
+	if err != nil {
+		return err
+	}
+	return db.Ref(a[0]).Remove()
+}
+
+func query(db *firebasedb.Reference, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	orderBy := fs.String("order-by", "$key", `child to order by ("$key", "$value" or the path of a child)`)
+	startAt := fs.String("start-at", "", "first value")
+	endAt := fs.String("end-at", "", "last value")
+	equalTo := fs.String("equal-to", "", "value of the children")
+	first := fs.Int("limit-to-first", 0, "limit to the first n children")
+	last := fs.Int("limit-to-last", 0, "limit to the last n children")
+	a, err := command(fs, args, 1, "[flags] <path>")
+	if err != nil {
+		return err
+	}
+	set := map[string]bool{} // the flags given on the command line, even if empty
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	convert := queryValue
+	if *orderBy == "$key" {
+		convert = keyValue
+	}
+	ref := db.Ref(a[0]).OrderByChild(*orderBy)
+	if set["start-at"] {
+		ref = ref.StartAt(convert(*startAt))
+	}
+	if set["end-at"] {
+		ref = ref.EndAt(convert(*endAt))
+	}
+	if set["equal-to"] {
+		ref = ref.EqualTo(convert(*equalTo))
+	}
+	if *first > 0 {
+		ref = ref.LimitToFirst(*first)
+	}
+	if *last > 0 {
+		ref = ref.LimitToLast(*last)
+	}
+	if ref.Error != nil {
+		return ref.Error
+	}
+	var value json.RawMessage
+	if err = ref.Value(&value); err != nil {
+		return err
+	}
+	return printJSON(value)
+}
+
+func listen(db *firebasedb.Reference, args []string) error {
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	keepAlive := fs.Bool("keep-alive", false, "also print the keep-alive events")
+	a, err := command(fs, args, 1, "[flags] <path>")
+	if err != nil {
+		return err
+	}
+	s, err := db.Ref(a[0]).PassKeepAlive(*keepAlive).Subscribe()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		s.Close()
+	}()
+	enc := json.NewEncoder(stdout)
+	for e := range s.Events() {
+		if e.Err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", e.Type, e.Err)
+			continue
+		}
+		var data json.RawMessage
+		path, err := e.Value(&data)
+		if err != nil && e.Type != "keep-alive" {
+			return err
+		}
+		if len(data) == 0 {
+			data = json.RawMessage("null")
+		}
+		if err = enc.Encode(map[string]interface{}{"event": e.Type, "path": path, "data": data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rules(db *firebasedb.Reference, args []string) error {
+	if len(args) == 2 && args[0] == "set" {
+		return db.SetRulesFromFile(args[1])
+	}
+	if len(args) == 1 && args[0] == "get" {
+		data, err := db.RawRules()
+		if err != nil {
+			return err
+		}
+		_, err = stdout.Write(data)
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "usage: firebasedb rules get | firebasedb rules set <file>")
+	os.Exit(2)
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: firebasedb [global flags] <command> [flags] <path> [value]")
+		fmt.Fprintf(os.Stderr, "commands: %v\n", commandList)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	db, err := database()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "get":
+		err = get(db, args)
+	case "shallow":
+		err = get(db, append([]string{"-shallow"}, args...))
+	case "set", "update", "push":
+		err = write(db, flag.Arg(0), args)
+	case "remove":
+		err = remove(db, args)
+	case "query":
+		err = query(db, args)
+	case "listen":
+		err = listen(db, args)
+	case "rules":
+		err = rules(db, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BlueMasters/firebasedb"
+	"github.com/stretchr/testify/assert"
+)
+
+// capture returns what f prints on stdout.
+func capture(t *testing.T, f func() error) string {
+	file, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	assert.NoError(t, err)
+	defer file.Close()
+	defer func(saved *os.File) { stdout = saved }(stdout)
+	stdout = file
+	assert.NoError(t, f())
+	data, err := ioutil.ReadFile(file.Name())
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestReadValue(t *testing.T) {
+	v, err := readValue(`{"name":"Ada"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"Ada"}`, string(v))
+	_, err = readValue(`{"name":`)
+	assert.Error(t, err)
+	_, err = readValue(`Ada`)
+	assert.Error(t, err)
+
+	file := filepath.Join(t.TempDir(), "stdin")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`[1, 2]`), 0644))
+	in, err := os.Open(file)
+	assert.NoError(t, err)
+	defer in.Close()
+	defer func(saved *os.File) { os.Stdin = saved }(os.Stdin)
+	os.Stdin = in
+	v, err = readValue("-")
+	assert.NoError(t, err)
+	assert.Equal(t, `[1, 2]`, string(v))
+}
+
+func TestQueryValue(t *testing.T) {
+	assert.Equal(t, "42", queryValue(`"42"`))
+	assert.Equal(t, 42, queryValue("42"))
+	assert.Equal(t, 4.5, queryValue("4.5"))
+	assert.Equal(t, "Ada", queryValue("Ada"))
+	assert.Equal(t, "", queryValue(`""`))
+	assert.Equal(t, "42", keyValue("42"))
+	assert.Equal(t, "42", keyValue(`"42"`))
+	assert.Equal(t, "ada", keyValue("ada"))
+}
+
+func TestCommands(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		body = bytes.TrimSpace(body)
+		requests = append(requests, fmt.Sprintf("%v %v?%v %s", req.Method, req.URL.Path, req.URL.RawQuery, body))
+		switch req.Method {
+		case "POST":
+			fmt.Fprint(w, `{"name":"-Kabc"}`)
+		case "DELETE":
+			fmt.Fprint(w, `null`)
+		default:
+			fmt.Fprint(w, `{"ada":{"age":36}}`)
+		}
+	}))
+	defer server.Close()
+	db := firebasedb.NewReference(server.URL)
+
+	assert.Equal(t, "{\n  \"ada\": {\n    \"age\": 36\n  }\n}\n", capture(t, func() error {
+		return get(db, []string{"users"})
+	}))
+	capture(t, func() error { return get(db, []string{"-export", "users"}) })
+	capture(t, func() error { return get(db, []string{"-shallow", "users"}) })
+	assert.Equal(t, "", capture(t, func() error { return write(db, "set", []string{"users/ada", `{"age":36}`}) }))
+	capture(t, func() error { return write(db, "update", []string{"users/ada", `{"age":37}`}) })
+	assert.Equal(t, "-Kabc\n", capture(t, func() error { return write(db, "push", []string{"users", `"Bob"`}) }))
+	capture(t, func() error { return remove(db, []string{"users/bob"}) })
+	assert.Equal(t, []string{
+		"GET /users.json? ",
+		"GET /users.json?format=export ",
+		"GET /users.json?shallow=true ",
+		`PUT /users/ada.json? {"age":36}`,
+		`PATCH /users/ada.json? {"age":37}`,
+		`POST /users.json? "Bob"`,
+		"DELETE /users/bob.json? ",
+	}, requests)
+
+	assert.Error(t, write(db, "set", []string{"users/ada", `{"age":`}))
+	assert.Equal(t, 7, len(requests))
+}
+
+func TestQuery(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		queries = append(queries, req.URL.RawQuery)
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+	db := firebasedb.NewReference(server.URL)
+
+	for _, args := range [][]string{
+		{"users"},
+		{"-order-by", "age", "-start-at", "18", "-end-at", "4.5", "-limit-to-first", "2", "users"},
+		{"-order-by", "zip", "-equal-to", `"42"`, "users"},
+		{"-order-by", "$value", "-start-at", "Ada", "-limit-to-last", "3", "users"},
+		{"-start-at", "10", "-end-at", "20", "users"},
+		{"-order-by", "name", "-equal-to", "", "users"},
+	} {
+		assert.Equal(t, "{}\n", capture(t, func() error { return query(db, args) }))
+	}
+	assert.Equal(t, []string{
+		"orderBy=%22%24key%22",
+		"endAt=4.5&limitToFirst=2&orderBy=%22age%22&startAt=18",
+		"equalTo=%2242%22&orderBy=%22zip%22",
+		"limitToLast=3&orderBy=%22%24value%22&startAt=%22Ada%22",
+		"endAt=%2220%22&orderBy=%22%24key%22&startAt=%2210%22",
+		"equalTo=%22%22&orderBy=%22name%22",
+	}, queries)
+}
+
+func TestListen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: put\ndata: {\"path\":\"/\",\"data\":{\"age\":36}}\n\n")
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+	db := firebasedb.NewReference(server.URL)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	assert.NoError(t, err)
+	file.Close() // the events can't be written
+	defer func(saved *os.File) { stdout = saved }(stdout)
+	stdout = file
+	assert.Error(t, listen(db, []string{"users"}))
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Decoder reads and decodes JSON values from an input stream.
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+// Codec is the interface used to encode the values written to the database and to
+// decode the values read from it. A codec must produce and accept standard JSON and
+// should follow the conventions of encoding/json (struct tags, json.Marshaler, ...).
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	NewDecoder(r io.Reader) Decoder
+}
+
+// JSONCodec is the default codec, based on encoding/json. When UseNumber is true,
+// the numbers decoded into an interface{} are returned as json.Number instead of
+// float64, so that large integers (such as 64-bit IDs) are not rounded.
+type JSONCodec struct {
+	UseNumber bool
+}
+
+// Marshal implements Codec.
+func (c JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements Codec.
+func (c JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return c.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// NewDecoder implements Codec.
+func (c JSONCodec) NewDecoder(r io.Reader) Decoder {
+	d := json.NewDecoder(r)
+	if c.UseNumber {
+		d.UseNumber()
+	}
+	return d
+}
+
+// defaultCodec is used when no codec is set on the reference.
+var defaultCodec Codec = JSONCodec{}
+
+// WithCodec returns a reference that uses codec to encode and decode the values.
+// If codec is nil, the default JSONCodec is used.
+func (r *Reference) WithCodec(codec Codec) *Reference {
+	result := *r
+	result.codec = codec
+	return &result
+}
+
+// UseNumber is a shortcut for WithCodec(JSONCodec{UseNumber: true}).
+func (r *Reference) UseNumber() *Reference {
+	return r.WithCodec(JSONCodec{UseNumber: true})
+}
+
+// getCodec returns the codec of the reference.
+func (r *Reference) getCodec() Codec {
+	if r.codec == nil {
+		return defaultCodec
+	}
+	return r.codec
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingCodec struct {
+	JSONCodec
+	marshal, decode int
+}
+
+func (c *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	c.marshal++
+	return c.JSONCodec.Marshal(v)
+}
+
+func (c *countingCodec) NewDecoder(r io.Reader) Decoder {
+	c.decode++
+	return c.JSONCodec.NewDecoder(r)
+}
+
+func TestCodec(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Accept") == "text/event-stream" {
+			fmt.Fprint(w, "event: put\ndata: {\"path\":\"/\",\"data\":{\"id\":9007199254740993}}\n\n")
+			return
+		}
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+		w.Write([]byte(`{"id":9007199254740993}`))
+	}))
+	defer server.Close()
+	ref := NewReference(server.URL).Ref("items/a")
+
+	var v map[string]interface{}
+	assert.NoError(t, ref.Value(&v))
+	assert.Equal(t, 9007199254740992.0, v["id"])
+	assert.NoError(t, ref.UseNumber().Value(&v))
+	assert.Equal(t, json.Number("9007199254740993"), v["id"])
+	assert.NoError(t, ref.Set(v))
+	assert.Equal(t, `{"id":9007199254740993}`, body)
+
+	sub, err := ref.UseNumber().Subscribe()
+	assert.NoError(t, err)
+	defer sub.Close()
+	event := <-sub.Events()
+	path, err := event.Value(&v)
+	assert.NoError(t, err)
+	assert.Equal(t, "/", path)
+	assert.Equal(t, json.Number("9007199254740993"), v["id"])
+
+	codec := &countingCodec{}
+	ref = ref.WithCodec(codec)
+	assert.NoError(t, ref.Update(map[string]int{"x": 1}))
+	assert.NoError(t, ref.Value(&v))
+	assert.Equal(t, 1, codec.marshal)
+	assert.Equal(t, 1, codec.decode)
+}
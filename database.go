@@ -0,0 +1,197 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	urllib "net/url"
+	"sync"
+)
+
+// Config is the configuration of a Database. Only URL is required.
+type Config struct {
+	URL           string            // URL of the database, for example https://<ns>.firebaseio.com
+	Auth          Authenticator     // credentials (no authentication if nil)
+	HTTPClient    *http.Client      // client used for the requests (see Transport)
+	Transport     http.RoundTripper // used when HTTPClient is nil (a private transport if both are nil)
+	RetryPolicy   *RetryPolicy      // no retry if nil
+	Logger        *slog.Logger
+	Debug         io.Writer
+	PassKeepAlive bool
+	Codec         Codec
+	Metrics       Metrics
+	Tracer        Tracer
+	Limiter       *Limiter
+	ReadCache     *ReadCache
+	EmulatorHost  string // address of the emulator (FIREBASE_DATABASE_EMULATOR_HOST by default)
+}
+
+// Database owns the configuration and the shared state of a database: the
+// authenticator, the HTTP connections and the active subscriptions. It hands
+// out References that all share this state. Close shuts everything down.
+//
+// See https://firebase.google.com/docs/reference/js/firebase.database.Database
+// for more details.
+type Database struct {
+	root      *Reference
+	client    *http.Client
+	ownClient bool // true if the client was created by NewDatabase
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	mu            sync.Mutex
+	subscriptions map[*Subscription]bool
+	closed        bool
+}
+
+// NewDatabase creates a Database from the configuration. It returns an error
+// if the URL is not valid. When an emulator is configured, the URL is rewritten
+// with EmulatorURL and the references are authenticated with EmulatorOwner,
+// unless config.Auth is set (for example to test the security rules).
+func NewDatabase(config Config) (*Database, error) {
+	var err error
+	if config.EmulatorHost == "" {
+		config.EmulatorHost = EmulatorHost()
+	}
+	if config.EmulatorHost != "" {
+		if config.URL, err = EmulatorURL(config.URL, config.EmulatorHost); err != nil {
+			return nil, err
+		}
+		if config.Auth == nil {
+			config.Auth = EmulatorOwner{}
+		}
+	}
+	u, err := urllib.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New(fmt.Sprintf("Invalid database URL: %v", config.URL))
+	}
+	db := &Database{subscriptions: map[*Subscription]bool{}}
+	db.ctx, db.cancel = context.WithCancel(context.Background())
+	db.client = config.HTTPClient
+	if db.client == nil {
+		transport := config.Transport
+		if transport == nil {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+			db.ownClient = true
+		}
+		db.client = &http.Client{Transport: transport}
+	}
+	root := NewReference(config.URL).Ref("/")
+	root.client = db.client
+	root.auth = config.Auth
+	root.debug = config.Debug
+	root.passKeepAlive = config.PassKeepAlive
+	root.codec = config.Codec
+	root.logger = config.Logger
+	root.metrics = config.Metrics
+	root.tracer = config.Tracer
+	root.limiter = config.Limiter
+	root.readCache = config.ReadCache
+	root.ctx = db.ctx
+	root.db = db
+	db.root = root.WithRetryPolicy(config.RetryPolicy)
+	return db, nil
+}
+
+// Ref returns a reference to the root or the specified path of the database.
+// The context of the reference is canceled when the database is closed.
+func (db *Database) Ref(path string) *Reference {
+	return db.root.Ref(path)
+}
+
+// RefFromUrl returns a reference to the path specified in url. The error of the
+// reference is set if the host of the url is not the host of the database.
+func (db *Database) RefFromUrl(url urllib.URL) *Reference {
+	return db.root.RefFromUrl(url)
+}
+
+// Subscriptions returns the number of active subscriptions opened on references
+// of the database.
+func (db *Database) Subscriptions() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return len(db.subscriptions)
+}
+
+// Close closes all active subscriptions, cancels the pending requests and
+// releases the idle connections. The requests made with the references of the
+// database fail after Close. Calling Close more than once has no effect.
+func (db *Database) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	subscriptions := make([]*Subscription, 0, len(db.subscriptions))
+	for s := range db.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	db.mu.Unlock()
+
+	var err error
+	for _, s := range subscriptions {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	db.cancel()
+	if db.ownClient {
+		db.client.CloseIdleConnections()
+	}
+	return err
+}
+
+// errDatabaseClosed is returned for the requests made after Database.Close.
+var errDatabaseClosed = errors.New("The database is closed")
+
+// checkOpen returns an error if the database of the reference was closed.
+func (r *Reference) checkOpen() error {
+	if r.db == nil {
+		return nil
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if r.db.closed {
+		return errDatabaseClosed
+	}
+	return nil
+}
+
+// track registers an active subscription. It fails if the database was closed.
+func (db *Database) track(s *Subscription) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return errDatabaseClosed
+	}
+	db.subscriptions[s] = true
+	return nil
+}
+
+// untrack removes a subscription when its stream is closed.
+func (db *Database) untrack(s *Subscription) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.subscriptions, s)
+}
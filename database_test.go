@@ -20,8 +20,10 @@ import (
 	"github.com/cenkalti/backoff"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 )
 
 const dinoFactsUrl = "https://dinosaur-facts.firebaseio.com/"
@@ -327,3 +329,54 @@ func TestCustomClient(t *testing.T) {
 	assert.Contains(t, scores, "pterodactyl")
 	assert.EqualValues(t, scores["pterodactyl"], 93)
 }
+
+func TestNewDatabase(t *testing.T) {
+	_, err := NewDatabase(Config{URL: "not a database"})
+	assert.Error(t, err)
+
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotAuth = req.URL.Query().Get("auth")
+		fmt.Fprint(w, `"Ada"`)
+	}))
+	defer server.Close()
+	db, err := NewDatabase(Config{URL: server.URL, Auth: Secret{Token: "secret"}})
+	assert.NoError(t, err)
+	ref := db.Ref("users").Child("ada")
+	assert.Equal(t, server.URL+"/users/ada", ref.String())
+	var name string
+	assert.NoError(t, ref.Value(&name))
+	assert.Equal(t, "Ada", name)
+	assert.Equal(t, "secret", gotAuth)
+
+	assert.NoError(t, db.Close())
+	assert.Equal(t, errDatabaseClosed, ref.Value(&name))
+	assert.NoError(t, db.Close())
+}
+
+func TestDatabaseClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "event: put\ndata: {\"path\":\"/\",\"data\":1}\n\n")
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+	db, err := NewDatabase(Config{URL: server.URL})
+	assert.NoError(t, err)
+	sub, err := db.Ref("counter").Subscribe()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, db.Subscriptions())
+	e := <-sub.Events()
+	assert.Equal(t, "put", e.Type)
+
+	assert.NoError(t, db.Close())
+	select {
+	case _, ok := <-sub.Events():
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("the subscription was not closed")
+	}
+	assert.Equal(t, 0, db.Subscriptions())
+	_, err = db.Ref("counter").Subscribe()
+	assert.Equal(t, errDatabaseClosed, err)
+}
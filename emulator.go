@@ -0,0 +1,104 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.google.com/docs/emulator-suite/connect_rtdb
+
+package firebasedb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	urllib "net/url"
+	"os"
+	"strings"
+)
+
+// EmulatorHostEnv is the environment variable with the address (host:port) of
+// the Realtime Database emulator.
+const EmulatorHostEnv = "FIREBASE_DATABASE_EMULATOR_HOST"
+
+// EmulatorHost returns the address of the emulator set in the environment, or
+// an empty string if the emulator is not used.
+func EmulatorHost() string {
+	return os.Getenv(EmulatorHostEnv)
+}
+
+// EmulatorOwner implements the Authenticator interface with the "owner" token
+// accepted by the emulator. The requests bypass the security rules.
+type EmulatorOwner struct{}
+
+// String returns "owner"
+func (EmulatorOwner) String() string {
+	return "owner"
+}
+
+// ParamName returns "access_token"
+func (EmulatorOwner) ParamName() string {
+	return "access_token"
+}
+
+// Renew does nothing, the owner token never expires.
+func (EmulatorOwner) Renew() error {
+	return nil
+}
+
+// EmulatorURL rewrites the URL of a database for the emulator running at host.
+// The namespace is the first label of the host name of url (for example "sample-app"
+// for https://sample-app.firebaseio.com) or the "ns" parameter if url already has
+// one. The result has the form http://<host>/<path>?ns=<namespace>.
+func EmulatorURL(url, host string) (string, error) {
+	u, err := urllib.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	ns := q.Get("ns")
+	if ns == "" {
+		name := u.Hostname()
+		if net.ParseIP(name) == nil && strings.Contains(name, ".") {
+			ns = strings.SplitN(name, ".", 2)[0]
+		}
+	}
+	if ns == "" {
+		return "", errors.New(fmt.Sprintf("Can't find the namespace of %v", url))
+	}
+	q.Set("ns", ns)
+	u.Scheme = "http"
+	u.Host = host
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// NewEmulatorAwareReference creates a new reference at url like NewReference. If
+// the FIREBASE_DATABASE_EMULATOR_HOST environment variable is set, the reference
+// points to the emulator instead (see EmulatorURL) and is authenticated with
+// EmulatorOwner.
+func NewEmulatorAwareReference(url string) *Reference {
+	host := EmulatorHost()
+	if host == "" {
+		return NewReference(url)
+	}
+	emulatorUrl, err := EmulatorURL(url, host)
+	if err != nil {
+		return &Reference{Error: err}
+	}
+	return NewReference(emulatorUrl).Auth(EmulatorOwner{})
+}
+
+// namespace returns the "ns" parameter of the reference (set for the emulator).
+func (r *Reference) namespace() string {
+	return r.url.Query().Get("ns")
+}
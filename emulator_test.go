@@ -0,0 +1,96 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmulatorURL(t *testing.T) {
+	for in, out := range map[string]string{
+		"https://sample-app.firebaseio.com":                                  "http://localhost:9000?ns=sample-app",
+		"https://sample-app.firebaseio.com/users/ada":                        "http://localhost:9000/users/ada?ns=sample-app",
+		"https://sample-app-default-rtdb.europe-west1.firebasedatabase.app/": "http://localhost:9000/?ns=sample-app-default-rtdb",
+		"http://127.0.0.1:8080/users?ns=demo":                                "http://localhost:9000/users?ns=demo",
+	} {
+		u, err := EmulatorURL(in, "localhost:9000")
+		assert.NoError(t, err)
+		assert.Equal(t, out, u)
+	}
+	_, err := EmulatorURL("http://127.0.0.1:8080/users", "localhost:9000")
+	assert.Error(t, err)
+}
+
+func TestEmulatorReference(t *testing.T) {
+	t.Setenv(EmulatorHostEnv, "")
+	ref := NewEmulatorAwareReference("https://sample-app.firebaseio.com/users")
+	assert.Equal(t, "https://sample-app.firebaseio.com/users", ref.String())
+	assert.Nil(t, ref.auth)
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.URL.Path+" "+req.URL.Query().Get("ns")+" "+req.URL.Query().Get("access_token"))
+		fmt.Fprint(w, `"Ada"`)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	t.Setenv(EmulatorHostEnv, host)
+
+	ref = NewEmulatorAwareReference("https://sample-app.firebaseio.com/users").Child("ada")
+	assert.NoError(t, ref.Error)
+	assert.Equal(t, server.URL+"/users/ada?ns=sample-app", ref.String())
+	assert.Equal(t, "ada", ref.Key())
+	assert.Equal(t, server.URL+"/users?ns=sample-app", ref.Parent().String())
+	assert.Equal(t, "", ref.Root().Key())
+	var name string
+	assert.NoError(t, ref.Value(&name))
+	assert.NoError(t, ref.Root().OrderByKey().Value(&name))
+	assert.Equal(t, []string{"/users/ada.json sample-app owner", "/.json sample-app owner"}, requests)
+
+	assert.NoError(t, ref.RefFromUrl(url.URL{Host: host, Path: "/users/bob", RawQuery: "ns=sample-app"}).Error)
+	assert.Error(t, ref.RefFromUrl(url.URL{Host: host, Path: "/users/bob", RawQuery: "ns=other"}).Error)
+
+	assert.Error(t, NewEmulatorAwareReference("http://127.0.0.1/users").Error)
+}
+
+func TestEmulatorDatabase(t *testing.T) {
+	t.Setenv(EmulatorHostEnv, "localhost:9000")
+	db, err := NewDatabase(Config{URL: "https://sample-app.firebaseio.com", Auth: Secret{Token: "secret"}})
+	assert.NoError(t, err)
+	defer db.Close()
+	ref := db.Ref("users/ada")
+	assert.Equal(t, "http://localhost:9000/users/ada?ns=sample-app", ref.String())
+	assert.Equal(t, Secret{Token: "secret"}, ref.auth) // the credentials of the caller are kept
+
+	db, err = NewDatabase(Config{URL: "https://sample-app.firebaseio.com", EmulatorHost: "127.0.0.1:9001"})
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, "http://127.0.0.1:9001/?ns=sample-app", db.Ref("").String())
+	assert.Equal(t, EmulatorOwner{}, db.Ref("").auth)
+
+	c, err := NewShardedClient(Config{}, PrefixRouter(map[string]int{"logs": 1}, 0),
+		Shard{URL: "https://users-app.firebaseio.com"}, Shard{URL: "https://logs-app.firebaseio.com"})
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, "http://localhost:9000/users/ada?ns=users-app", c.Ref("users/ada").String())
+	assert.Equal(t, "http://localhost:9000/logs?ns=logs-app", c.Ref("logs").String())
+}
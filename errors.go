@@ -0,0 +1,114 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ResponseError is returned when the server answers with an error status. Message
+// is the error message returned by the server (if any).
+type ResponseError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("error, response is : %v", e.Status)
+	}
+	return fmt.Sprintf("error, response is : %v (%v)", e.Status, e.Message)
+}
+
+// WriteTooBigError is returned when a write is rejected because it is larger than
+// the limit set with WriteSizeLimit (or than the maximum size of a request).
+type WriteTooBigError struct {
+	ResponseError
+}
+
+// Unwrap returns the ResponseError, so that errors.As finds it.
+func (e *WriteTooBigError) Unwrap() error {
+	return &e.ResponseError
+}
+
+// TimeoutError is returned when a read doesn't complete within the time set with Timeout.
+type TimeoutError struct {
+	ResponseError
+}
+
+// Unwrap returns the ResponseError, so that errors.As finds it.
+func (e *TimeoutError) Unwrap() error {
+	return &e.ResponseError
+}
+
+// The messages of the server (in lower case) for the writes rejected by
+// writeSizeLimit and the reads interrupted by timeout. They come with a 400 status.
+var (
+	writeTooBigMessages = []string{"write_too_big", "write too big"}
+	timeoutMessages     = []string{"timeout", "the query timed out"}
+)
+
+// isMessage returns true if message is one of messages (ignoring the case).
+func isMessage(message string, messages []string) bool {
+	message = strings.ToLower(message)
+	for _, m := range messages {
+		if message == m {
+			return true
+		}
+	}
+	return false
+}
+
+// responseError reads the error message from an unsuccessful response and returns
+// the corresponding error. It does not close the body.
+func responseError(response *http.Response) error {
+	e := ResponseError{StatusCode: response.StatusCode, Status: response.Status}
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		e.Message = payload.Error
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	badRequest := response.StatusCode == http.StatusBadRequest
+	switch {
+	case response.StatusCode == http.StatusRequestEntityTooLarge ||
+		badRequest && isMessage(e.Message, writeTooBigMessages):
+		return &WriteTooBigError{e}
+	case response.StatusCode == http.StatusRequestTimeout ||
+		badRequest && isMessage(e.Message, timeoutMessages):
+		return &TimeoutError{e}
+	}
+	return &e
+}
+
+// errorStatus returns the status code of an error returned for an unsuccessful
+// response, or 0 for the other errors.
+func errorStatus(err error) int {
+	var e *ResponseError
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,190 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.google.com/docs/database/security/indexing-data
+
+package firebasedb
+
+import (
+	pathlib "path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// QueryUsage describes how many times a query ordered by OrderBy was run on Path.
+// OrderBy is the name of the child as it appears in an ".indexOn" rule (".value"
+// for OrderByValue).
+type QueryUsage struct {
+	Path    string
+	OrderBy string
+	Count   int
+}
+
+// QueryRecorder records the orderBy clauses of the queries executed by Value and
+// Subscribe. The recorder is safe for concurrent use and can be shared by all
+// references of an application.
+type QueryRecorder struct {
+	mu    sync.Mutex
+	usage map[[2]string]int
+}
+
+// NewQueryRecorder returns an empty QueryRecorder.
+func NewQueryRecorder() *QueryRecorder {
+	return &QueryRecorder{usage: map[[2]string]int{}}
+}
+
+// RecordQueries returns a reference that records its queries in rec. The recorder
+// is inherited by all references derived from the returned one.
+func (r *Reference) RecordQueries(rec *QueryRecorder) *Reference {
+	result := *r
+	result.recorder = rec
+	return &result
+}
+
+// recordQuery records the orderBy clause of the reference (if any).
+func (r *Reference) recordQuery() {
+	if r.recorder == nil {
+		return
+	}
+	orderBy := strings.Trim(r.url.Query().Get("orderBy"), `"`)
+	switch orderBy {
+	case "", "$key", "$priority": // always indexed
+		return
+	case "$value":
+		orderBy = ".value"
+	}
+	path := pathlib.Clean(pathlib.Join("/", r.url.Path))
+	r.recorder.mu.Lock()
+	defer r.recorder.mu.Unlock()
+	r.recorder.usage[[2]string{path, orderBy}]++
+}
+
+// sortedUsage converts the usage map to a sorted slice.
+func sortedUsage(usage map[[2]string]int) []QueryUsage {
+	var result []QueryUsage
+	for k, count := range usage {
+		result = append(result, QueryUsage{Path: k[0], OrderBy: k[1], Count: count})
+	}
+	sort.Sort(byPathAndOrder(result))
+	return result
+}
+
+type byPathAndOrder []QueryUsage
+
+func (a byPathAndOrder) Len() int      { return len(a) }
+func (a byPathAndOrder) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byPathAndOrder) Less(i, j int) bool {
+	if a[i].Path != a[j].Path {
+		return a[i].Path < a[j].Path
+	}
+	return a[i].OrderBy < a[j].OrderBy
+}
+
+// Usage returns the recorded queries sorted by path.
+func (rec *QueryRecorder) Usage() []QueryUsage {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return sortedUsage(rec.usage)
+}
+
+// Reset clears the recorded queries.
+func (rec *QueryRecorder) Reset() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.usage = map[[2]string]int{}
+}
+
+// rulesLocation finds the rules node matching path. It returns the node (or nil if the
+// rules don't go that deep) and the path of the location in the rules, where the keys
+// matching a wildcard are replaced by the name of the wildcard.
+func rulesLocation(root *RuleNode, path string) (*RuleNode, string) {
+	node := root
+	var location []string
+	for _, key := range splitPath(path) {
+		if node != nil {
+			child, ok := node.Children[key]
+			if !ok {
+				for name, wildcard := range node.Children {
+					if strings.HasPrefix(name, "$") {
+						child, key = wildcard, name
+					}
+				}
+			}
+			node = child
+		}
+		location = append(location, key)
+	}
+	return node, "/" + strings.Join(location, "/")
+}
+
+// ruleAt returns the node at the location (as returned by rulesLocation) or nil.
+func ruleAt(root *RuleNode, location []string) *RuleNode {
+	node := root
+	for _, key := range location {
+		if node == nil {
+			return nil
+		}
+		node = node.Children[key]
+	}
+	return node
+}
+
+// MissingIndexes compares the recorded queries with the ".indexOn" rules and returns
+// the queries that are not indexed. The path of the returned usages is the path of the
+// location in the rules (for example "/users/$uid/posts"), and the counts of the queries
+// that map to the same location are added.
+func (rec *QueryRecorder) MissingIndexes(rules *Rules) []QueryUsage {
+	missing := map[[2]string]int{}
+	for _, u := range rec.Usage() {
+		node, location := rulesLocation(rules.Root, u.Path)
+		indexed := false
+		if node != nil {
+			for _, index := range node.IndexOn {
+				indexed = indexed || index == u.OrderBy
+			}
+		}
+		if !indexed {
+			missing[[2]string{location, u.OrderBy}] += u.Count
+		}
+	}
+	return sortedUsage(missing)
+}
+
+// IndexPatch returns a rules document that only contains the ".indexOn" rules
+// needed for the recorded queries that are not indexed by rules. The existing
+// indexes of the locations are included, so that the patch can be merged into
+// the rules without losing them.
+func (rec *QueryRecorder) IndexPatch(rules *Rules) *Rules {
+	patch := &Rules{Root: &RuleNode{Children: map[string]*RuleNode{}}}
+	for _, u := range rec.MissingIndexes(rules) {
+		node := patch.Root
+		for _, key := range splitPath(u.Path) {
+			child, ok := node.Children[key]
+			if !ok {
+				child = &RuleNode{Children: map[string]*RuleNode{}}
+				node.Children[key] = child
+			}
+			node = child
+		}
+		if len(node.IndexOn) == 0 {
+			if existing := ruleAt(rules.Root, splitPath(u.Path)); existing != nil {
+				node.IndexOn = append(node.IndexOn, existing.IndexOn...)
+			}
+		}
+		node.IndexOn = append(node.IndexOn, u.OrderBy)
+	}
+	return patch
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryRecorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	rec := NewQueryRecorder()
+	db := NewReference(server.URL).RecordQueries(rec)
+	var v map[string]interface{}
+	assert.NoError(t, db.Ref("/users/ada/posts").OrderByChild("date").Value(&v))
+	assert.NoError(t, db.Ref("/users/bob/posts").OrderByChild("date").Value(&v))
+	assert.NoError(t, db.Ref("/users/bob/posts").OrderByKey().Value(&v))
+	assert.NoError(t, db.Ref("/scores").OrderByValue().LimitToLast(3).Value(&v))
+	assert.NoError(t, db.Ref("/dinosaurs").OrderByChild("height").Value(&v))
+	assert.NoError(t, db.Ref("/dinosaurs").Value(&v))
+	assert.Equal(t, []QueryUsage{
+		{Path: "/dinosaurs", OrderBy: "height", Count: 1},
+		{Path: "/scores", OrderBy: ".value", Count: 1},
+		{Path: "/users/ada/posts", OrderBy: "date", Count: 1},
+		{Path: "/users/bob/posts", OrderBy: "date", Count: 1},
+	}, rec.Usage())
+
+	rules, err := ParseRules([]byte(`{"rules": {
+		"users": {"$uid": {"posts": {".indexOn": "title"}}},
+		"dinosaurs": {".indexOn": ["height"]}
+	}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []QueryUsage{
+		{Path: "/scores", OrderBy: ".value", Count: 1},
+		{Path: "/users/$uid/posts", OrderBy: "date", Count: 2},
+	}, rec.MissingIndexes(rules))
+
+	b, err := json.Marshal(rec.IndexPatch(rules))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"rules": {
+		"scores": {".indexOn": [".value"]},
+		"users": {"$uid": {"posts": {".indexOn": ["title", "date"]}}}
+	}}`, string(b))
+
+	rec.Reset()
+	assert.Empty(t, rec.Usage())
+}
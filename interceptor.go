@@ -0,0 +1,183 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	urllib "net/url"
+	pathlib "path"
+)
+
+// Operation describes an operation on the database, as seen by the interceptors.
+// The interceptors can modify the fields before calling the next invoker.
+type Operation struct {
+	Name   string // get, set, update, push, remove or subscribe
+	Method string // HTTP method
+	Path   string // path of the location, for example "/users/ada"
+	Query  urllib.Values
+	Header http.Header
+	Value  interface{} // value written by set, update and push
+
+	// Result is where the response is decoded (nil if the response is ignored).
+	// An interceptor that doesn't call the next invoker can fill it with SetResult.
+	Result interface{}
+	// Status is the status code of the response, set by the execution.
+	Status int
+	// Attempts is the number of times the request was sent, set by the execution
+	// (0 if the result came from the read cache).
+	Attempts int
+	// Body is the stream of events of a subscribe operation (or the response read
+	// by Reference.ReadChildren), set by the execution. An interceptor that doesn't
+	// call the next invoker must set it for a subscribe operation.
+	Body io.ReadCloser
+
+	ref      *Reference
+	keepBody bool // the body is returned instead of being decoded
+	shared   bool // the result may be shared with other readers (read cache)
+}
+
+// Invoker executes an operation.
+type Invoker func(ctx context.Context, op *Operation) error
+
+// Interceptor is called for each operation, before its execution. It can inspect or
+// modify the operation, call next to execute it (and then inspect the result), or
+// return without calling next to short-circuit the execution.
+//
+// For example, an interceptor that audits the writes:
+//
+//	func audit(ctx context.Context, op *firebasedb.Operation, next firebasedb.Invoker) error {
+//		err := next(ctx, op)
+//		if op.Method != "GET" {
+//			log.Printf("%s %s: status=%d err=%v", op.Name, op.Path, op.Status, err)
+//		}
+//		return err
+//	}
+type Interceptor func(ctx context.Context, op *Operation, next Invoker) error
+
+// Intercept returns a reference whose operations go through the interceptors. The
+// interceptors are appended to those of r and are called in order: the first one
+// is the outermost.
+func (r *Reference) Intercept(interceptors ...Interceptor) *Reference {
+	result := *r
+	result.interceptors = append(append([]Interceptor(nil), r.interceptors...), interceptors...)
+	return &result
+}
+
+// SetResult decodes data (the JSON representation of the response) into the Result
+// of the operation.
+func (op *Operation) SetResult(data []byte) error {
+	if op.Result == nil {
+		return nil
+	}
+	return unmarshalValue(op.ref.getCodec(), data, op.Result, pathlib.Base(op.Path))
+}
+
+// newOperation returns the operation for a request on the reference.
+func (r *Reference) newOperation(name, method string, value, result interface{}) *Operation {
+	return &Operation{
+		Name:   name,
+		Method: method,
+		Path:   pathlib.Clean("/" + r.url.Path),
+		Query:  r.url.Query(),
+		Header: http.Header{},
+		Value:  value,
+		Result: result,
+		ref:    r,
+	}
+}
+
+// invoke executes the operation through the interceptors and sets the Attempts
+// of the reference (except for the reads that may be shared by the read cache).
+func (r *Reference) invoke(ctx context.Context, op *Operation) error {
+	err := r.intercept(ctx, op)
+	if !op.shared {
+		r.Attempts = op.Attempts
+	}
+	return err
+}
+
+// intercept executes the operation through the interceptors.
+func (r *Reference) intercept(ctx context.Context, op *Operation) error {
+	if r.sharded != nil {
+		routed, err := r.resolve()
+		if err != nil {
+			return err
+		}
+		op.ref = routed
+		return routed.intercept(ctx, op)
+	}
+	if err := r.checkOpen(); err != nil {
+		return err
+	}
+	var chain func(i int) Invoker
+	chain = func(i int) Invoker {
+		if i == len(r.interceptors) {
+			return r.execute
+		}
+		return func(ctx context.Context, op *Operation) error {
+			return r.interceptors[i](ctx, op, chain(i+1))
+		}
+	}
+	return chain(0)(ctx, op)
+}
+
+// execute executes an operation, through the read cache if the reference has one.
+func (r *Reference) execute(ctx context.Context, op *Operation) error {
+	if r.readCache != nil {
+		return r.executeCached(ctx, op)
+	}
+	return r.executeRequest(ctx, op)
+}
+
+// executeRequest sends the request of an operation.
+func (r *Reference) executeRequest(ctx context.Context, op *Operation) error {
+	target := *r
+	target.url.Path = op.Path
+	target.url.RawQuery = op.Query.Encode()
+	defer func() { op.Attempts = target.Attempts }()
+
+	var body io.Reader
+	if op.Method == "PUT" || op.Method == "PATCH" || op.Method == "POST" {
+		var err error
+		body, err = target.body(op.Value, op.Method == "PATCH")
+		if err != nil {
+			return errors.New(fmt.Sprintf("error reading body: %v", err))
+		}
+	}
+	response, err := target.send(ctx, op.Method, body, op.Header)
+	if err != nil {
+		op.Status = errorStatus(err)
+		return err
+	}
+	op.Status = response.StatusCode
+	if op.Name == OpSubscribe || op.keepBody {
+		op.Body = response.Body
+		return nil
+	}
+	defer response.Body.Close()
+	if op.Result == nil {
+		io.Copy(ioutil.Discard, response.Body) // allows the connection to be reused
+		return nil
+	}
+	if err = decodeValue(target.getCodec(), response.Body, op.Result, target.Key()); err != nil {
+		return errors.New(fmt.Sprintf("error decoding the result: %v", err))
+	}
+	return nil
+}
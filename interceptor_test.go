@@ -0,0 +1,109 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptors(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+" "+req.URL.Path+"?"+req.URL.RawQuery+" "+req.Header.Get("X-Tenant"))
+		w.Write([]byte(`{"name":"-K1"}`))
+	}))
+	defer server.Close()
+
+	var audit []string
+	auditor := func(ctx context.Context, op *Operation, next Invoker) error {
+		err := next(ctx, op)
+		audit = append(audit, fmt.Sprintf("%s %s %v %d", op.Name, op.Path, op.Value, op.Status))
+		return err
+	}
+	tenant := func(ctx context.Context, op *Operation, next Invoker) error {
+		op.Header.Set("X-Tenant", "acme")
+		op.Path = "/tenants/acme" + op.Path
+		return next(ctx, op)
+	}
+	ref := NewReference(server.URL).Intercept(auditor).Intercept(tenant).Ref("users/ada")
+
+	assert.NoError(t, ref.Set("Ada"))
+	name, err := ref.Push(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "-K1", name)
+	var v interface{}
+	assert.NoError(t, ref.OrderByKey().Value(&v))
+	assert.Equal(t, []string{
+		"PUT /tenants/acme/users/ada.json? acme",
+		"POST /tenants/acme/users/ada.json? acme",
+		"GET /tenants/acme/users/ada.json?orderBy=%22%24key%22 acme",
+	}, requests)
+	assert.Equal(t, []string{
+		"set /tenants/acme/users/ada Ada 200",
+		"push /tenants/acme/users/ada 1 200",
+		"get /tenants/acme/users/ada <nil> 200",
+	}, audit)
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	ref := NewReference("http://localhost:1").Intercept(func(ctx context.Context, op *Operation, next Invoker) error {
+		switch op.Name {
+		case OpGet:
+			return op.SetResult([]byte(`{"cached":true}`))
+		case OpSubscribe:
+			op.Body = ioutil.NopCloser(strings.NewReader("event: put\ndata: {\"path\":\"/\",\"data\":42}\n\n"))
+			return nil
+		}
+		return errors.New("read-only")
+	})
+
+	var v map[string]interface{}
+	assert.NoError(t, ref.Value(&v))
+	assert.Equal(t, map[string]interface{}{"cached": true}, v)
+	assert.EqualError(t, ref.Set(1), "read-only")
+
+	sub, err := ref.Subscribe()
+	assert.NoError(t, err)
+	var n int
+	event := <-sub.Events()
+	_, err = event.Value(&n)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, n)
+	sub.Close()
+}
+
+func TestInterceptReadChildren(t *testing.T) {
+	ref := NewReference("http://localhost:1").Intercept(func(ctx context.Context, op *Operation, next Invoker) error {
+		return op.SetResult([]byte(`{"a":1,"b":2}`))
+	})
+	reader, err := ref.ReadChildren()
+	assert.NoError(t, err)
+	defer reader.Close()
+	var keys []string
+	for reader.Next() {
+		keys = append(keys, reader.Key())
+	}
+	assert.NoError(t, reader.Err())
+	assert.Equal(t, []string{"a", "b"}, keys)
+}
@@ -0,0 +1,321 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// DefaultPageSize is the number of children fetched per request by an Iterator.
+const DefaultPageSize = 1000
+
+// compareKeys compares two keys using the order of the database: the keys that
+// can be parsed as 32-bit integers come first, sorted numerically, followed by the
+// other keys sorted lexicographically.
+func compareKeys(a, b string) int {
+	ia, aIsInt := intKey(a)
+	ib, bIsInt := intKey(b)
+	switch {
+	case aIsInt && bIsInt:
+		if ia < ib {
+			return -1
+		} else if ia > ib {
+			return 1
+		}
+		return 0
+	case aIsInt:
+		return -1
+	case bIsInt:
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// intKey returns the integer value of a key and true if the key is an integer key.
+func intKey(key string) (int64, bool) {
+	i, err := strconv.ParseInt(key, 10, 64)
+	if err != nil || i < math.MinInt32 || i > math.MaxInt32 || strconv.FormatInt(i, 10) != key {
+		return 0, false
+	}
+	return i, true
+}
+
+type byKey []string
+
+func (a byKey) Len() int           { return len(a) }
+func (a byKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byKey) Less(i, j int) bool { return compareKeys(a[i], a[j]) < 0 }
+
+// iteratorChild is a child returned by an iterator.
+type iteratorChild struct {
+	key string
+	raw json.RawMessage
+}
+
+// iteratorPage is the result of a page request.
+type iteratorPage struct {
+	children []iteratorChild
+	err      error
+}
+
+// Iterator pages through the children of a node in key order. It is used to
+// read collections that are too large to be read with a single request. The
+// iterator is created with Reference.Iterate and is used like a bufio.Scanner:
+//
+//	it := ref.Iterate(500)
+//	defer it.Close()
+//	for it.Next() {
+//		fmt.Println(it.Key(), string(it.Raw()))
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// The iteration can be resumed after a failure by creating a new iterator with
+// StartAfter(it.Cursor()).
+type Iterator struct {
+	ref         *Reference
+	pageSize    int
+	concurrency int
+	cursor      string
+	hasCursor   bool
+
+	started bool
+	done    bool
+	page    []iteratorChild
+	pages   chan chan iteratorPage // concurrent mode
+	stop    chan struct{}
+	closed  bool
+	current iteratorChild
+	err     error
+}
+
+// Iterate returns an iterator on the children of the reference. The children are
+// fetched by pages of pageSize children (DefaultPageSize if pageSize <= 0) using
+// OrderByKey, StartAt and LimitToFirst.
+func (r *Reference) Iterate(pageSize int) *Iterator {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return &Iterator{ref: r, pageSize: pageSize, concurrency: 1}
+}
+
+// Concurrency sets the number of pages fetched in parallel. When n > 1, the iterator
+// first reads all keys with a shallow request and then fetches the pages with n
+// parallel requests. The children are still returned in key order.
+func (it *Iterator) Concurrency(n int) *Iterator {
+	result := *it
+	if n < 1 {
+		n = 1
+	}
+	result.concurrency = n
+	return &result
+}
+
+// StartAfter returns an iterator that starts after the child with the key cursor.
+// It is used to resume an interrupted iteration.
+func (it *Iterator) StartAfter(cursor string) *Iterator {
+	result := *it
+	result.cursor = cursor
+	result.hasCursor = true
+	return &result
+}
+
+// readPage reads the children of the reference into a slice sorted by key.
+func readPage(r *Reference) ([]iteratorChild, error) {
+	reader, err := r.ReadChildren()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var children []iteratorChild
+	for reader.Next() {
+		children = append(children, iteratorChild{key: reader.Key(), raw: reader.Raw()})
+	}
+	if err = reader.Err(); err != nil {
+		return nil, err
+	}
+	sort.Sort(byChildKey(children))
+	return children, nil
+}
+
+type byChildKey []iteratorChild
+
+func (a byChildKey) Len() int           { return len(a) }
+func (a byChildKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byChildKey) Less(i, j int) bool { return compareKeys(a[i].key, a[j].key) < 0 }
+
+// fetchPage reads the next page after the cursor in sequential mode.
+func (it *Iterator) fetchPage() error {
+	q := it.ref.OrderByKey()
+	limit := it.pageSize
+	if it.hasCursor {
+		limit++ // StartAt includes the cursor
+		q = q.StartAt(it.cursor)
+	}
+	q = q.LimitToFirst(limit)
+	if q.Error != nil {
+		return q.Error
+	}
+	children, err := readPage(q)
+	if err != nil {
+		return err
+	}
+	if len(children) < limit {
+		it.done = true
+	}
+	it.page = it.page[:0]
+	for _, child := range children {
+		if !it.hasCursor || compareKeys(child.key, it.cursor) > 0 {
+			it.page = append(it.page, child)
+		}
+	}
+	return nil
+}
+
+// startConcurrent lists the keys and starts the workers fetching the pages. The
+// server rejects shallow with the other parameters (format, orderBy, ...), so the
+// keys are listed without them and the parameters are used to read the pages.
+func (it *Iterator) startConcurrent() error {
+	reader, err := it.ref.withoutParams().Shallow().ReadChildren()
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for reader.Next() {
+		if !it.hasCursor || compareKeys(reader.Key(), it.cursor) > 0 {
+			keys = append(keys, reader.Key())
+		}
+	}
+	reader.Close()
+	if err = reader.Err(); err != nil {
+		return err
+	}
+	sort.Sort(byKey(keys))
+
+	it.stop = make(chan struct{})
+	it.pages = make(chan chan iteratorPage, it.concurrency-1)
+	go func() {
+		defer close(it.pages)
+		for start := 0; start < len(keys); start += it.pageSize {
+			end := start + it.pageSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			result := make(chan iteratorPage, 1)
+			select {
+			case it.pages <- result:
+			case <-it.stop:
+				return
+			}
+			go func(batch []string) {
+				q := it.ref.OrderByKey().StartAt(batch[0]).EndAt(batch[len(batch)-1])
+				children, err := readPage(q)
+				result <- iteratorPage{children: children, err: err}
+			}(keys[start:end])
+		}
+	}()
+	return nil
+}
+
+// nextPage loads the next page. It returns false if there are no more pages.
+func (it *Iterator) nextPage() bool {
+	if it.concurrency > 1 {
+		result, ok := <-it.pages
+		if !ok {
+			return false
+		}
+		page := <-result
+		it.page, it.err = page.children, page.err
+		return it.err == nil
+	}
+	if it.done {
+		return false
+	}
+	it.err = it.fetchPage()
+	return it.err == nil
+}
+
+// Next advances the iterator to the next child. It returns false when there are
+// no more children or when an error occurred.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.started {
+		it.started = true
+		if it.concurrency > 1 {
+			if it.err = it.startConcurrent(); it.err != nil {
+				return false
+			}
+		}
+	}
+	for len(it.page) == 0 {
+		if !it.nextPage() {
+			it.current = iteratorChild{}
+			it.Close()
+			return false
+		}
+	}
+	it.current, it.page = it.page[0], it.page[1:]
+	it.cursor, it.hasCursor = it.current.key, true
+	return true
+}
+
+// Key returns the key of the current child.
+func (it *Iterator) Key() string {
+	return it.current.key
+}
+
+// Raw returns the JSON representation of the current child.
+func (it *Iterator) Raw() json.RawMessage {
+	return it.current.raw
+}
+
+// Value decodes the current child into v.
+func (it *Iterator) Value(v interface{}) error {
+	if it.current.raw == nil {
+		return errors.New("No current child")
+	}
+	return unmarshalValue(it.ref.getCodec(), it.current.raw, v, it.current.key)
+}
+
+// Cursor returns the key of the last child returned by Next. Use StartAfter
+// with the cursor to resume the iteration.
+func (it *Iterator) Cursor() string {
+	return it.cursor
+}
+
+// Err returns the error that stopped the iteration.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close stops the iterator and the background requests. It is only needed when
+// the iteration is stopped before Next returns false.
+func (it *Iterator) Close() {
+	if it.stop != nil && !it.closed {
+		close(it.stop)
+	}
+	it.closed = true
+}
@@ -0,0 +1,146 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collectionServer serves a collection of n children and implements the key
+// queries used by the iterator.
+func collectionServer(t *testing.T, keys []string, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(requests, 1)
+		q := req.URL.Query()
+		if q.Get("shallow") == "true" {
+			if len(q) > 1 {
+				http.Error(w, `{"error":"shallow can't be used with other parameters"}`, http.StatusBadRequest)
+				return
+			}
+			m := map[string]bool{}
+			for _, k := range keys {
+				m[k] = true
+			}
+			json.NewEncoder(w).Encode(m)
+			return
+		}
+		assert.Equal(t, `"$key"`, q.Get("orderBy"))
+		sorted := append([]string{}, keys...)
+		sort.Sort(byKey(sorted))
+		m := map[string]interface{}{}
+		limit, _ := strconv.Atoi(q.Get("limitToFirst"))
+		for _, k := range sorted {
+			if s := q.Get("startAt"); s != "" && compareKeys(k, strings.Trim(s, `"`)) < 0 {
+				continue
+			}
+			if e := q.Get("endAt"); e != "" && compareKeys(k, strings.Trim(e, `"`)) > 0 {
+				continue
+			}
+			if limit > 0 && len(m) == limit {
+				break
+			}
+			if q.Get("format") == "export" {
+				m[k] = map[string]string{"key": k, ".priority": "p"}
+			} else {
+				m[k] = map[string]string{"key": k}
+			}
+		}
+		json.NewEncoder(w).Encode(m)
+	}))
+}
+
+func TestCompareKeys(t *testing.T) {
+	keys := []string{"b", "10", "a", "-1", "2", "01", "2147483648", "A"}
+	sort.Sort(byKey(keys))
+	assert.Equal(t, []string{"-1", "2", "10", "01", "2147483648", "A", "a", "b"}, keys)
+}
+
+func TestIterator(t *testing.T) {
+	var keys []string
+	for i := 0; i < 95; i++ {
+		keys = append(keys, fmt.Sprintf("key-%03d", i))
+	}
+	keys = append(keys, "7", "12", "300")
+	expected := append([]string{}, keys...)
+	sort.Sort(byKey(expected))
+
+	var requests int32
+	server := collectionServer(t, keys, &requests)
+	defer server.Close()
+	db := NewReference(server.URL)
+
+	for _, concurrency := range []int{1, 4} {
+		atomic.StoreInt32(&requests, 0)
+		it := db.Iterate(10).Concurrency(concurrency)
+		var got []string
+		for it.Next() {
+			var v map[string]string
+			assert.NoError(t, it.Value(&v))
+			assert.Equal(t, it.Key(), v["key"])
+			got = append(got, it.Key())
+		}
+		assert.NoError(t, it.Err())
+		assert.Equal(t, expected, got, "concurrency %v", concurrency)
+		assert.True(t, atomic.LoadInt32(&requests) >= 10)
+
+		// resume in the middle
+		it = db.Iterate(7).Concurrency(concurrency).StartAfter(expected[41])
+		got = nil
+		for it.Next() {
+			got = append(got, it.Key())
+		}
+		assert.NoError(t, it.Err())
+		assert.Equal(t, expected[42:], got)
+		assert.Equal(t, expected[len(expected)-1], it.Cursor())
+	}
+
+	// stop early
+	it := db.Iterate(5).Concurrency(3)
+	assert.True(t, it.Next())
+	it.Close()
+}
+
+func TestIteratorExport(t *testing.T) {
+	var keys []string
+	for i := 0; i < 25; i++ {
+		keys = append(keys, fmt.Sprintf("key-%03d", i))
+	}
+	var requests int32
+	server := collectionServer(t, keys, &requests)
+	defer server.Close()
+
+	for _, concurrency := range []int{1, 4} {
+		it := NewReference(server.URL).Export().Iterate(10).Concurrency(concurrency)
+		count := 0
+		for it.Next() {
+			var v map[string]string
+			assert.NoError(t, it.Value(&v))
+			assert.Equal(t, "p", v[".priority"])
+			count++
+		}
+		assert.NoError(t, it.Err(), "concurrency %v", concurrency)
+		assert.Equal(t, len(keys), count)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.google.com/docs/database/usage/limits
+
+package firebasedb
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter limits the rate of the requests (with a token bucket) and the number of
+// requests in flight. A request is in flight until the body of its response is
+// closed, so that a subscription holds its slot until it is closed. A limiter is
+// safe for concurrent use and is shared by all the references derived from the
+// reference on which it is set.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second (0 means no limit)
+	burst  float64
+	tokens float64
+	last   time.Time
+	slots  chan struct{} // nil means no limit
+}
+
+// LimiterMetrics is implemented by the metrics (see WithMetrics) that record the
+// time spent waiting for a limiter.
+type LimiterMetrics interface {
+	ObserveWait(operation string, wait time.Duration)
+}
+
+// NewLimiter returns a limiter that allows ratePerSecond requests per second, with
+// bursts of up to burst requests, and at most maxInFlight requests in flight. A zero
+// rate or maxInFlight means no limit.
+func NewLimiter(ratePerSecond float64, burst int, maxInFlight int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &Limiter{rate: ratePerSecond, burst: float64(burst), tokens: float64(burst)}
+	if maxInFlight > 0 {
+		l.slots = make(chan struct{}, maxInFlight)
+	}
+	return l
+}
+
+// WithLimiter returns a reference whose requests and stream opens are limited by l.
+func (r *Reference) WithLimiter(l *Limiter) *Reference {
+	result := *r
+	result.limiter = l
+	return &result
+}
+
+// reserve takes a token and returns the time to wait before using it.
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel gives back a reserved token.
+func (l *Limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate > 0 {
+		l.tokens++
+	}
+}
+
+// take blocks until a token is available. The token is given back if ctx is
+// done before. The retries of a request take a token each (see doAttempts).
+func (l *Limiter) take(ctx context.Context) error {
+	if delay := l.reserve(time.Now()); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			l.cancel()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Wait blocks until a request is allowed. It returns a function that must be
+// called when the request is finished.
+func (l *Limiter) Wait(ctx context.Context) (release func(), err error) {
+	if err = l.take(ctx); err != nil {
+		return nil, err
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		l.cancel() // the request is not sent
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-l.slots }) }, nil
+}
+
+// limitedBody is the body of a response that releases its limiter slot when it is closed.
+type limitedBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterInFlight(t *testing.T) {
+	var mu sync.Mutex
+	current, max := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		current++
+		if current > max {
+			max = current
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		current--
+		mu.Unlock()
+		w.Write([]byte("null"))
+	}))
+	defer server.Close()
+	db := NewReference(server.URL).WithLimiter(NewLimiter(0, 0, 2))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, db.Ref(fmt.Sprintf("items/%d", i)).Set(i))
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 2, max)
+}
+
+func TestLimiterRate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("null"))
+	}))
+	defer server.Close()
+	db := NewReference(server.URL).WithLimiter(NewLimiter(100, 1, 0))
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, db.Set(i))
+	}
+	assert.True(t, time.Since(start) >= 45*time.Millisecond)
+}
+
+func TestLimiterRetries(t *testing.T) {
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		times = append(times, time.Now())
+		if len(times) < 4 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("null"))
+	}))
+	defer server.Close()
+	db := NewReference(server.URL).WithLimiter(NewLimiter(50, 1, 0)).
+		WithRetryPolicy(&RetryPolicy{BackOff: fastBackOff(), MaxAttempts: 4})
+	assert.NoError(t, db.Set(1))
+	assert.Equal(t, 4, len(times))
+	assert.True(t, times[3].Sub(times[0]) >= 55*time.Millisecond) // 20ms per retry
+}
+
+func TestLimiterCanceledWait(t *testing.T) {
+	l := NewLimiter(1, 2, 1)
+	release, err := l.Wait(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = l.Wait(ctx) // takes the second token, but the slot is busy
+	assert.Error(t, err)
+
+	release()
+	start := time.Now()
+	release, err = l.Wait(context.Background()) // the token was given back
+	assert.NoError(t, err)
+	release()
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestLimiterStream(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Accept") == "text/event-stream" {
+			w.(http.Flusher).Flush()
+			<-block
+			return
+		}
+		w.Write([]byte("null"))
+	}))
+	defer server.Close()
+	defer close(block)
+	db := NewReference(server.URL).WithLimiter(NewLimiter(0, 0, 1))
+
+	sub, err := db.Subscribe()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Error(t, db.WithContext(ctx).Set(1)) // the subscription holds the only slot
+
+	sub.Close()
+	assert.NoError(t, db.Set(1))
+}
@@ -0,0 +1,164 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	urllib "net/url"
+	"sync"
+	"time"
+)
+
+// redactedParams are the query parameters that are never logged.
+var redactedParams = []string{"auth", "access_token", "auth_variable_override"}
+
+// WithLogger returns a reference that logs its requests and streams with logger.
+// Each request is logged when its response is closed, at the debug level if it
+// succeeded and at the warning level otherwise, with the method, the path, the
+// query (without the credentials), the status, the latency, the number of attempts
+// and the sizes of the bodies. The streams also log their opening, closing,
+// reconnections and each received event.
+func (r *Reference) WithLogger(logger *slog.Logger) *Reference {
+	result := *r
+	result.logger = logger
+	return &result
+}
+
+// LogBodies sets the number of bytes of the request and response bodies that are
+// included in the logs. The default is 0 (the bodies are not logged).
+func (r *Reference) LogBodies(maxBytes int) *Reference {
+	result := *r
+	result.logBodies = maxBytes
+	return &result
+}
+
+// RedactPath sets the function used to hide the sensitive parts of the paths (user
+// IDs, email addresses, ...) in the logs. By default, the paths are logged as is.
+func (r *Reference) RedactPath(redact func(path string) string) *Reference {
+	result := *r
+	result.redactPath = redact
+	return &result
+}
+
+// logPath returns the path of the URL as it is logged.
+func (r *Reference) logPath(u *urllib.URL) string {
+	if r.redactPath != nil {
+		return r.redactPath(u.Path)
+	}
+	return u.Path
+}
+
+// redactedURL returns the URL without the credentials.
+func redactedURL(u *urllib.URL) *urllib.URL {
+	result := *u
+	q := result.Query()
+	for _, p := range redactedParams {
+		if _, ok := q[p]; ok {
+			q.Set(p, "REDACTED")
+		}
+	}
+	result.RawQuery = q.Encode()
+	return &result
+}
+
+// truncate returns at most n bytes of b as a string, followed by "..." if b is
+// longer.
+func truncate(b []byte, n int) string {
+	if len(b) > n {
+		return string(b[:n]) + "..."
+	}
+	return string(b)
+}
+
+// requestLog collects the information logged for a request.
+type requestLog struct {
+	ref         *Reference
+	req         *http.Request
+	start       time.Time
+	latency     time.Duration
+	status      int
+	requestBody []byte
+	size        int
+	body        []byte
+	err         error
+}
+
+func (l *requestLog) log() {
+	r := l.ref
+	level := slog.LevelDebug
+	if l.err != nil {
+		level = slog.LevelWarn
+	}
+	attrs := []slog.Attr{
+		slog.String("method", l.req.Method),
+		slog.String("path", r.logPath(l.req.URL)),
+		slog.String("query", redactedURL(l.req.URL).RawQuery),
+		slog.Int("status", l.status),
+		slog.Duration("latency", l.latency),
+		slog.Int("attempts", r.Attempts),
+		slog.Int64("request_size", l.req.ContentLength),
+		slog.Int("response_size", l.size),
+	}
+	if r.logBodies > 0 {
+		response := string(l.body) // only the first logBodies bytes are kept
+		if l.size > len(l.body) {
+			response += "..."
+		}
+		attrs = append(attrs,
+			slog.String("request_body", truncate(l.requestBody, r.logBodies)),
+			slog.String("response_body", response))
+	}
+	if l.err != nil {
+		attrs = append(attrs, slog.String("error", l.err.Error()))
+	}
+	r.logger.LogAttrs(context.Background(), level, "firebasedb request", attrs...)
+}
+
+// loggedBody is the body of a response that logs the request when it is closed.
+type loggedBody struct {
+	io.ReadCloser
+	entry *requestLog
+	once  sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.entry.size += n
+	if missing := b.entry.ref.logBodies - len(b.entry.body); missing > 0 {
+		if missing > n {
+			missing = n
+		}
+		b.entry.body = append(b.entry.body, p[:missing]...)
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.entry.log)
+	return err
+}
+
+// logStream logs an event of the life of a stream.
+func (r *Reference) logStream(msg string, attrs ...slog.Attr) {
+	if r.logger == nil {
+		return
+	}
+	attrs = append([]slog.Attr{slog.String("path", r.logPath(&r.url))}, attrs...)
+	r.logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs...)
+}
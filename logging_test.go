@@ -0,0 +1,96 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	buf.Reset()
+	return entries
+}
+
+func TestLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Header.Get("Accept") == "text/event-stream":
+			fmt.Fprint(w, "event: put\ndata: {\"path\":\"/\",\"data\":1}\n\n")
+		case req.Method == "DELETE":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"Permission denied"}`))
+		default:
+			w.Write([]byte(`{"name":"Ada Lovelace"}`))
+		}
+	}))
+	defer server.Close()
+	buf := new(bytes.Buffer)
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ref := NewReference(server.URL).Auth(&Secret{Token: "s3cr3t"}).WithLogger(logger).
+		RedactPath(func(p string) string { return strings.Replace(p, "ada", "***", -1) }).
+		LogBodies(8).Ref("users/ada")
+
+	assert.NoError(t, ref.Set(map[string]string{"name": "Ada Lovelace"}))
+	entries := logEntries(t, buf)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "DEBUG", entries[0]["level"])
+	assert.Equal(t, "PUT", entries[0]["method"])
+	assert.Equal(t, "/users/***.json", entries[0]["path"])
+	assert.Equal(t, "auth=REDACTED", entries[0]["query"])
+	assert.Equal(t, 200.0, entries[0]["status"])
+	assert.Equal(t, 1.0, entries[0]["attempts"])
+	assert.Equal(t, 23.0, entries[0]["request_size"])
+	assert.Equal(t, 23.0, entries[0]["response_size"])
+	assert.Equal(t, `{"name":...`, entries[0]["request_body"])
+	assert.Equal(t, `{"name":...`, entries[0]["response_body"])
+	assert.NotContains(t, buf.String(), "s3cr3t")
+
+	assert.Error(t, ref.Remove())
+	entries = logEntries(t, buf)
+	assert.Equal(t, "WARN", entries[0]["level"])
+	assert.Equal(t, 403.0, entries[0]["status"])
+	assert.Contains(t, entries[0]["error"], "Permission denied")
+
+	sub, err := ref.Subscribe()
+	assert.NoError(t, err)
+	for range sub.Events() {
+	}
+	sub.Close()
+	var messages []interface{}
+	for _, entry := range logEntries(t, buf) {
+		messages = append(messages, entry["msg"])
+	}
+	assert.Equal(t, []interface{}{
+		"firebasedb stream opened",
+		"firebasedb stream event",
+		"firebasedb stream closed",
+		"firebasedb request",
+	}, messages)
+}
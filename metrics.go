@@ -0,0 +1,81 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"net/http"
+	"time"
+)
+
+// The operations reported to the metrics.
+const (
+	OpGet       = "get"
+	OpSet       = "set"
+	OpUpdate    = "update"
+	OpPush      = "push"
+	OpRemove    = "remove"
+	OpSubscribe = "subscribe"
+)
+
+// RequestMetrics describes a request sent to the database. Status is 0 if no
+// response was received. Attempts is the number of times the request was sent
+// (more than 1 if it was retried).
+type RequestMetrics struct {
+	Operation string
+	Status    int
+	Attempts  int
+	Latency   time.Duration
+	Err       error
+}
+
+// Metrics is the interface used to instrument the references. The methods are
+// called concurrently and must not block. See the prometheus sub-package for an
+// implementation.
+type Metrics interface {
+	// ObserveRequest is called after each request (including the opening of a stream).
+	ObserveRequest(m RequestMetrics)
+	// ObserveEvent is called for each event received by a subscription.
+	ObserveEvent(eventType string)
+}
+
+// WithMetrics returns a reference that reports its requests and events to m.
+func (r *Reference) WithMetrics(m Metrics) *Reference {
+	result := *r
+	result.metrics = m
+	return &result
+}
+
+// methodOperation returns the name of the operation executed by a REST method.
+func methodOperation(method string) string {
+	switch method {
+	case "PUT":
+		return OpSet
+	case "PATCH":
+		return OpUpdate
+	case "POST":
+		return OpPush
+	case "DELETE":
+		return OpRemove
+	}
+	return OpGet
+}
+
+// operation returns the name of the operation executed by a request.
+func operation(req *http.Request) string {
+	if req.Method == "GET" && req.Header.Get("Accept") == "text/event-stream" {
+		return OpSubscribe
+	}
+	return methodOperation(req.Method)
+}
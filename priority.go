@@ -0,0 +1,134 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.google.com/docs/reference/rest/database/#section-param-format
+// https://firebase.google.com/docs/reference/js/firebase.database.Reference#setWithPriority
+// https://firebase.google.com/docs/reference/js/firebase.database.Reference#setPriority
+
+package firebasedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// checkPriority verifies that the priority is null, a number or a string.
+func checkPriority(priority interface{}) error {
+	switch priority.(type) {
+	case nil, string, float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, json.Number:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Invalid priority %v (must be null, a number or a string)", priority))
+}
+
+// SetWithPriority writes value and its priority at the location of the reference.
+// The priority must be nil, a number or a string.
+//
+// See https://firebase.google.com/docs/reference/js/firebase.database.Reference#setWithPriority
+// for more details.
+func (r *Reference) SetWithPriority(value interface{}, priority interface{}) error {
+	if err := checkPriority(priority); err != nil {
+		return err
+	}
+	reader, err := r.body(value, false)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error reading body: %v", err))
+	}
+	raw, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	var object map[string]json.RawMessage
+	if json.Unmarshal(raw, &object) == nil && object != nil {
+		p, _ := json.Marshal(priority)
+		object[".priority"] = p
+		return r.Set(object)
+	}
+	return r.Set(map[string]interface{}{
+		".value":    json.RawMessage(bytes.TrimSpace(raw)),
+		".priority": priority,
+	})
+}
+
+// SetPriority sets the priority of the data at the location of the reference.
+// The priority must be nil, a number or a string.
+//
+// See https://firebase.google.com/docs/reference/js/firebase.database.Reference#setPriority
+// for more details.
+func (r *Reference) SetPriority(priority interface{}) error {
+	if err := checkPriority(priority); err != nil {
+		return err
+	}
+	return r.Child(".priority").Set(priority)
+}
+
+// stripPriorities removes the priorities from an exported node and stores them
+// in priorities.
+func stripPriorities(node interface{}, path string, priorities map[string]interface{}) interface{} {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return node
+	}
+	if p, ok := m[".priority"]; ok {
+		if n, ok := p.(json.Number); ok {
+			p, _ = n.Float64()
+		}
+		priorities[path] = p
+		delete(m, ".priority")
+	}
+	if v, ok := m[".value"]; ok {
+		return v
+	}
+	for k, child := range m {
+		m[k] = stripPriorities(child, strings.TrimSuffix(path, "/")+"/"+k, priorities)
+	}
+	return m
+}
+
+// DecodeExport splits data in the export format (see Reference.Export) into the
+// value, decoded into value, and the priorities. The priorities are indexed by
+// the path of the node relative to the exported location ("/" for the location
+// itself) and are float64 or string values, as decoded by encoding/json.
+func DecodeExport(data []byte, value interface{}) (priorities map[string]interface{}, err error) {
+	var generic interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err = d.Decode(&generic); err != nil {
+		return nil, errors.New(fmt.Sprintf("error decoding the result: %v", err))
+	}
+	priorities = map[string]interface{}{}
+	b, err := json.Marshal(stripPriorities(generic, "/", priorities))
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, value); err != nil {
+		return nil, errors.New(fmt.Sprintf("error decoding the result: %v", err))
+	}
+	return priorities, nil
+}
+
+// ValueWithPriorities reads the data at the location of the reference, stores it
+// in value and returns the priorities (see DecodeExport).
+func (r *Reference) ValueWithPriorities(value interface{}) (priorities map[string]interface{}, err error) {
+	var raw json.RawMessage
+	if err = r.Export().Value(&raw); err != nil {
+		return nil, err
+	}
+	return DecodeExport(raw, value)
+}
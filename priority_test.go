@@ -0,0 +1,69 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeExport(t *testing.T) {
+	data := []byte(`{
+		".priority": 1,
+		"ada": {".priority": "a", "name": "Ada", "age": 36},
+		"bob": {".value": "Bob", ".priority": 2.5},
+		"eve": "Eve"
+	}`)
+	var v map[string]interface{}
+	priorities, err := DecodeExport(data, &v)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"ada": map[string]interface{}{"name": "Ada", "age": 36.0},
+		"bob": "Bob",
+		"eve": "Eve",
+	}, v)
+	assert.Equal(t, map[string]interface{}{
+		"/":    1.0,
+		"/ada": "a",
+		"/bob": 2.5,
+	}, priorities)
+}
+
+func TestSetWithPriority(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, req.URL.Path+" "+string(b))
+		w.Write([]byte("null"))
+	}))
+	defer server.Close()
+	db := NewReference(server.URL)
+
+	assert.NoError(t, db.Ref("a").SetWithPriority(map[string]int{"x": 1}, 10))
+	assert.NoError(t, db.Ref("b").SetWithPriority("text", "p"))
+	assert.NoError(t, db.Ref("c").SetPriority(nil))
+	assert.Error(t, db.Ref("c").SetPriority(true))
+	assert.Equal(t, []string{
+		"/a.json {\".priority\":10,\"x\":1}",
+		"/b.json {\".priority\":\"p\",\".value\":\"text\"}",
+		"/c/.priority.json null",
+	}, bodies)
+
+	assert.Equal(t, `"$priority"`, db.OrderByPriority().url.Query().Get("orderBy"))
+}
@@ -0,0 +1,220 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+// Package prometheus collects the metrics of firebasedb references and exposes
+// them in the Prometheus text format. It does not depend on the Prometheus client
+// library:
+//
+//	collector := prometheus.NewCollector()
+//	db := firebasedb.NewReference(url).WithMetrics(collector)
+//	http.Handle("/metrics", collector)
+package prometheus
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BlueMasters/firebasedb"
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the latency histogram.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram is a cumulative latency histogram.
+type histogram struct {
+	counts []uint64 // one per bucket
+	count  uint64
+	sum    float64
+}
+
+// Collector implements firebasedb.Metrics, firebasedb.LimiterMetrics and http.Handler.
+// It is safe for concurrent use.
+type Collector struct {
+	mu        sync.Mutex
+	buckets   []float64
+	requests  map[[2]string]uint64 // operation, status code
+	errors    map[string]uint64
+	retries   map[string]uint64
+	latencies map[string]*histogram
+	waits     map[string]*histogram
+	events    map[string]uint64
+}
+
+// NewCollector returns a collector using DefaultBuckets.
+func NewCollector() *Collector {
+	return NewCollectorWithBuckets(DefaultBuckets)
+}
+
+// NewCollectorWithBuckets returns a collector using the given (sorted) histogram buckets.
+func NewCollectorWithBuckets(buckets []float64) *Collector {
+	return &Collector{
+		buckets:   buckets,
+		requests:  map[[2]string]uint64{},
+		errors:    map[string]uint64{},
+		retries:   map[string]uint64{},
+		latencies: map[string]*histogram{},
+		waits:     map[string]*histogram{},
+		events:    map[string]uint64{},
+	}
+}
+
+// ObserveRequest implements firebasedb.Metrics.
+func (c *Collector) ObserveRequest(m firebasedb.RequestMetrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests[[2]string{m.Operation, strconv.Itoa(m.Status)}]++
+	if m.Err != nil {
+		c.errors[m.Operation]++
+	}
+	if m.Attempts > 1 {
+		c.retries[m.Operation] += uint64(m.Attempts - 1)
+	}
+	c.observe(c.latencies, m.Operation, m.Latency.Seconds())
+}
+
+// observe adds a value to the histogram of the operation.
+func (c *Collector) observe(histograms map[string]*histogram, op string, seconds float64) {
+	h, ok := histograms[op]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(c.buckets))}
+		histograms[op] = h
+	}
+	for i, bound := range c.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ObserveWait implements firebasedb.LimiterMetrics.
+func (c *Collector) ObserveWait(operation string, wait time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observe(c.waits, operation, wait.Seconds())
+}
+
+// ObserveEvent implements firebasedb.Metrics.
+func (c *Collector) ObserveEvent(eventType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events[eventType]++
+}
+
+// sortedKeys returns the keys of a map sorted.
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelEscaper escapes the label values: only the backslash, the double quote and
+// the line feed are escaped in the text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label formats a label value.
+func label(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// formatFloat formats a value as in the Prometheus text format.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteTo writes the metrics in the Prometheus text format. The metrics are
+// formatted first, so that a slow writer doesn't block the observations.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, c.text())
+	return int64(n), err
+}
+
+// text formats the metrics in the Prometheus text format.
+func (c *Collector) text() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var b strings.Builder
+
+	b.WriteString("# HELP firebasedb_requests_total Number of requests by operation and status code.\n")
+	b.WriteString("# TYPE firebasedb_requests_total counter\n")
+	var requests []string
+	for k, n := range c.requests {
+		requests = append(requests, fmt.Sprintf("firebasedb_requests_total{operation=%s,code=%s} %d\n", label(k[0]), label(k[1]), n))
+	}
+	sort.Strings(requests)
+	for _, line := range requests {
+		b.WriteString(line)
+	}
+
+	b.WriteString("# HELP firebasedb_request_errors_total Number of failed requests by operation.\n")
+	b.WriteString("# TYPE firebasedb_request_errors_total counter\n")
+	for _, op := range sortedKeys(c.errors) {
+		fmt.Fprintf(&b, "firebasedb_request_errors_total{operation=%s} %d\n", label(op), c.errors[op])
+	}
+
+	b.WriteString("# HELP firebasedb_request_retries_total Number of retried attempts by operation.\n")
+	b.WriteString("# TYPE firebasedb_request_retries_total counter\n")
+	for _, op := range sortedKeys(c.retries) {
+		fmt.Fprintf(&b, "firebasedb_request_retries_total{operation=%s} %d\n", label(op), c.retries[op])
+	}
+
+	c.writeHistograms(&b, "firebasedb_request_duration_seconds", "Latency of the requests by operation.", c.latencies)
+	c.writeHistograms(&b, "firebasedb_limiter_wait_seconds", "Time spent waiting for the limiter by operation.", c.waits)
+
+	b.WriteString("# HELP firebasedb_stream_events_total Number of streamed events by type.\n")
+	b.WriteString("# TYPE firebasedb_stream_events_total counter\n")
+	for _, t := range sortedKeys(c.events) {
+		fmt.Fprintf(&b, "firebasedb_stream_events_total{type=%s} %d\n", label(t), c.events[t])
+	}
+	return b.String()
+}
+
+// writeHistograms writes the histograms of the operations.
+func (c *Collector) writeHistograms(b *strings.Builder, name, help string, histograms map[string]*histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+	ops := make([]string, 0, len(histograms))
+	for op := range histograms {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		h := histograms[op]
+		for i, bound := range c.buckets {
+			fmt.Fprintf(b, "%s_bucket{operation=%s,le=%s} %d\n", name, label(op), label(formatFloat(bound)), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{operation=%s,le=\"+Inf\"} %d\n", name, label(op), h.count)
+		fmt.Fprintf(b, "%s_sum{operation=%s} %s\n", name, label(op), formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{operation=%s} %d\n", name, label(op), h.count)
+	}
+}
+
+// ServeHTTP implements http.Handler and serves the metrics in the Prometheus text format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BlueMasters/firebasedb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	db := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Header.Get("Accept") == "text/event-stream":
+			fmt.Fprint(w, "event: put\ndata: {\"path\":\"/\",\"data\":1}\n\nevent: patch\ndata: {\"path\":\"/\",\"data\":{}}\n\n")
+		case req.Method == "DELETE":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Write([]byte("null"))
+		}
+	}))
+	defer db.Close()
+	collector := NewCollectorWithBuckets([]float64{1, 10})
+	ref := firebasedb.NewReference(db.URL).WithMetrics(collector).WithLimiter(firebasedb.NewLimiter(0, 0, 1))
+
+	var v interface{}
+	assert.NoError(t, ref.Value(&v))
+	assert.NoError(t, ref.Value(&v))
+	assert.NoError(t, ref.Set(1))
+	assert.Error(t, ref.Remove())
+	sub, err := ref.Subscribe()
+	assert.NoError(t, err)
+	for range sub.Events() {
+	}
+	sub.Close()
+	collector.ObserveRequest(firebasedb.RequestMetrics{Operation: "get", Status: 200, Attempts: 3, Latency: 5 * time.Second})
+
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	text := string(body)
+
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	assert.Contains(t, text, `firebasedb_requests_total{operation="get",code="200"} 3`)
+	assert.Contains(t, text, `firebasedb_requests_total{operation="remove",code="401"} 1`)
+	assert.Contains(t, text, `firebasedb_request_errors_total{operation="remove"} 1`)
+	assert.Contains(t, text, `firebasedb_request_retries_total{operation="get"} 2`)
+	assert.Contains(t, text, `firebasedb_request_duration_seconds_bucket{operation="get",le="1"} 2`)
+	assert.Contains(t, text, `firebasedb_request_duration_seconds_bucket{operation="get",le="10"} 3`)
+	assert.Contains(t, text, `firebasedb_request_duration_seconds_count{operation="set"} 1`)
+	assert.Contains(t, text, `firebasedb_requests_total{operation="subscribe",code="200"} 1`)
+	assert.Contains(t, text, `firebasedb_stream_events_total{type="patch"} 1`)
+	assert.Contains(t, text, `firebasedb_stream_events_total{type="put"} 1`)
+	assert.Contains(t, text, `firebasedb_limiter_wait_seconds_count{operation="get"} 2`)
+}
+
+func TestLabel(t *testing.T) {
+	assert.Equal(t, `"get"`, label("get"))
+	assert.Equal(t, `"a\\b\"c\nd"`, label("a\\b\"c\nd"))
+	assert.Equal(t, "\"tab\there é\"", label("tab\there é"))
+}
+
+// blockingWriter blocks until it is released.
+type blockingWriter struct {
+	started chan bool
+	release chan bool
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.started <- true
+	<-w.release
+	return len(p), nil
+}
+
+func TestSlowScrape(t *testing.T) {
+	collector := NewCollector()
+	w := &blockingWriter{started: make(chan bool), release: make(chan bool)}
+	go collector.WriteTo(w)
+	<-w.started
+	done := make(chan bool)
+	go func() {
+		collector.ObserveRequest(firebasedb.RequestMetrics{Operation: "get", Status: 200, Attempts: 1})
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("ObserveRequest is blocked by the scrape")
+	}
+	close(w.release)
+}
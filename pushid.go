@@ -0,0 +1,84 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.googleblog.com/2015/02/the-2120-ways-to-ensure-unique_68.html
+
+package firebasedb
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// pushChars are the characters used in the push IDs, in ASCII order.
+const pushChars = "-0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz"
+
+var pushIDs = struct {
+	sync.Mutex
+	lastTime int64
+	lastRand [12]int
+}{}
+
+// NewPushID generates a key in the same format as the keys created by Push: 8
+// characters encoding the time in milliseconds followed by 12 random characters.
+// The keys are sorted by creation time, and the keys generated during the same
+// millisecond are sorted in the order of their creation.
+func NewPushID() string {
+	pushIDs.Lock()
+	defer pushIDs.Unlock()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if now == pushIDs.lastTime {
+		// increment the random part
+		i := len(pushIDs.lastRand) - 1
+		for ; i >= 0 && pushIDs.lastRand[i] == len(pushChars)-1; i-- {
+			pushIDs.lastRand[i] = 0
+		}
+		if i >= 0 {
+			pushIDs.lastRand[i]++
+		}
+	} else {
+		for i := range pushIDs.lastRand {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(pushChars))))
+			if err != nil {
+				panic(err)
+			}
+			pushIDs.lastRand[i] = int(n.Int64())
+		}
+		pushIDs.lastTime = now
+	}
+	id := make([]byte, 20)
+	for i := 7; i >= 0; i-- {
+		id[i] = pushChars[now%int64(len(pushChars))]
+		now /= int64(len(pushChars))
+	}
+	for i, n := range pushIDs.lastRand {
+		id[8+i] = pushChars[n]
+	}
+	return string(id)
+}
+
+// SafePush adds value to the list at the location of the reference, as Push does, but
+// the key is generated by the client (see NewPushID) and the value is written with a
+// PUT request. Unlike Push, the request can be retried without creating duplicated
+// children.
+func (r *Reference) SafePush(value interface{}) (name string, err error) {
+	name = NewPushID()
+	if err = r.Child(name).Set(value); err != nil {
+		return "", err
+	}
+	return name, nil
+}
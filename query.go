@@ -45,6 +45,15 @@ func (r *Reference) OrderByValue() *Reference {
 	return r.OrderByChild("$value")
 }
 
+// OrderByPriority generates a new query ordered by priority.
+//
+// See https://firebase.google.com/docs/reference/js/firebase.database.Reference#orderByPriority
+// or https://firebase.google.com/docs/reference/js/firebase.database.Query#orderByPriority
+// for more details
+func (r *Reference) OrderByPriority() *Reference {
+	return r.OrderByChild("$priority")
+}
+
 // LimitToFirst generates a new query limited to the first specific number of children.
 //
 // See https://firebase.google.com/docs/reference/js/firebase.database.Reference#limitToFirst
@@ -0,0 +1,153 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// ChildReader reads the children of a node one by one, while the response is
+// arriving. Only the current child is kept in memory, so it can be used to read
+// nodes that are too large for Value. A ChildReader is used like a bufio.Scanner:
+//
+//	reader, err := ref.ReadChildren()
+//	if err != nil {
+//		...
+//	}
+//	defer reader.Close()
+//	for reader.Next() {
+//		fmt.Println(reader.Key(), string(reader.Raw()))
+//	}
+//	if err := reader.Err(); err != nil {
+//		...
+//	}
+type ChildReader struct {
+	body  io.ReadCloser
+	dec   *json.Decoder
+	array bool // true if the node was returned as a JSON array
+	index int
+	done  bool
+	key   string
+	raw   json.RawMessage
+	err   error
+	codec Codec
+}
+
+// ReadChildren executes the query of the reference and returns a reader on the
+// children of the node. If the node is a primitive value or doesn't exist, then
+// the reader has no children. The reader must be closed after use.
+func (r *Reference) ReadChildren() (*ChildReader, error) {
+	r.recordQuery()
+	var raw json.RawMessage
+	op := r.newOperation(OpGet, "GET", nil, &raw)
+	op.keepBody = true
+	if err := r.invoke(r.Context(), op); err != nil {
+		return nil, err
+	}
+	if op.Body == nil { // an interceptor returned the result
+		op.Body = ioutil.NopCloser(bytes.NewReader(raw))
+	}
+	c := &ChildReader{body: op.Body, dec: json.NewDecoder(op.Body), codec: r.getCodec()}
+	token, err := c.dec.Token()
+	if err != nil {
+		op.Body.Close()
+		return nil, errors.New(fmt.Sprintf("error decoding the result: %v", err))
+	}
+	switch token {
+	case json.Delim('{'):
+	case json.Delim('['):
+		c.array = true
+	default: // primitive value or null
+		c.done = true
+	}
+	return c, nil
+}
+
+// Next advances the reader to the next child. It returns false when there are no
+// more children or when an error occurred.
+func (c *ChildReader) Next() bool {
+	for !c.done {
+		if !c.dec.More() {
+			c.done = true
+			if _, err := c.dec.Token(); err != nil { // closing delimiter
+				c.err = errors.New(fmt.Sprintf("error decoding the result: %v", err))
+			}
+			break
+		}
+		if c.array {
+			c.key = strconv.Itoa(c.index)
+			c.index++
+		} else {
+			token, err := c.dec.Token()
+			if err != nil {
+				c.fail(err)
+				break
+			}
+			c.key, _ = token.(string)
+		}
+		c.raw = nil
+		if err := c.dec.Decode(&c.raw); err != nil {
+			c.fail(err)
+			break
+		}
+		if c.array && string(c.raw) == "null" {
+			continue // missing element of a Firebase array
+		}
+		return true
+	}
+	c.key, c.raw = "", nil
+	return false
+}
+
+func (c *ChildReader) fail(err error) {
+	c.err = errors.New(fmt.Sprintf("error decoding the result: %v", err))
+	c.done = true
+}
+
+// Key returns the key of the current child. The key of an element of an array
+// is its index.
+func (c *ChildReader) Key() string {
+	return c.key
+}
+
+// Raw returns the JSON representation of the current child. The returned slice
+// is not modified by the next calls to Next.
+func (c *ChildReader) Raw() json.RawMessage {
+	return c.raw
+}
+
+// Value decodes the current child into v.
+func (c *ChildReader) Value(v interface{}) error {
+	if c.raw == nil {
+		return errors.New("No current child")
+	}
+	return unmarshalValue(c.codec, c.raw, v, c.key)
+}
+
+// Err returns the first error that occurred while reading the children.
+func (c *ChildReader) Err() error {
+	return c.err
+}
+
+// Close closes the underlying HTTP response.
+func (c *ChildReader) Close() error {
+	return c.body.Close()
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadChildren(t *testing.T) {
+	const n = 10000
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/big.json":
+			fmt.Fprint(w, "{")
+			for i := 0; i < n; i++ {
+				if i > 0 {
+					fmt.Fprint(w, ",")
+				}
+				fmt.Fprintf(w, `"k%05d": {"i": %d, "s": "%d"}`, i, i, i)
+			}
+			fmt.Fprint(w, "}")
+		case "/array.json":
+			fmt.Fprint(w, `["a", null, "c"]`)
+		case "/primitive.json":
+			fmt.Fprint(w, `42`)
+		default:
+			fmt.Fprint(w, `{"a": 1, "b": [}`)
+		}
+	}))
+	defer server.Close()
+	db := NewReference(server.URL)
+
+	reader, err := db.Ref("big").ReadChildren()
+	assert.NoError(t, err)
+	count := 0
+	for reader.Next() {
+		var v struct {
+			I int    `json:"i"`
+			S string `json:"s"`
+		}
+		assert.NoError(t, reader.Value(&v))
+		assert.Equal(t, fmt.Sprintf("k%05d", count), reader.Key())
+		assert.Equal(t, count, v.I)
+		count++
+	}
+	assert.NoError(t, reader.Err())
+	assert.NoError(t, reader.Close())
+	assert.Equal(t, n, count)
+
+	reader, err = db.Ref("array").ReadChildren()
+	assert.NoError(t, err)
+	var keys []string
+	for reader.Next() {
+		keys = append(keys, reader.Key()+"="+string(reader.Raw()))
+	}
+	assert.NoError(t, reader.Err())
+	assert.Equal(t, []string{`0="a"`, `2="c"`}, keys)
+	reader.Close()
+
+	reader, err = db.Ref("primitive").ReadChildren()
+	assert.NoError(t, err)
+	assert.False(t, reader.Next())
+	assert.NoError(t, reader.Err())
+	reader.Close()
+
+	reader, err = db.Ref("broken").ReadChildren()
+	assert.NoError(t, err)
+	assert.True(t, reader.Next())
+	assert.False(t, reader.Next())
+	assert.Error(t, reader.Err())
+	reader.Close()
+}
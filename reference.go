@@ -35,14 +35,18 @@
 package firebasedb
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	urllib "net/url"
 	pathlib "path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cenkalti/backoff"
 )
@@ -55,9 +59,26 @@ type Reference struct {
 	url           urllib.URL
 	client        *http.Client
 	auth          Authenticator
+	authOverride  *string // JSON encoded auth_variable_override
 	debug         io.Writer
 	passKeepAlive bool
 	retry         *backoff.ExponentialBackOff
+	retryPolicy   *RetryPolicy
+	recorder      *QueryRecorder
+
+	arraysAsObjects bool
+	codec           Codec
+	logger          *slog.Logger
+	logBodies       int
+	redactPath      func(string) string
+	metrics         Metrics
+	ctx             context.Context
+	tracer          Tracer
+	interceptors    []Interceptor
+	limiter         *Limiter
+	readCache       *ReadCache
+	db              *Database      // owner of the reference (nil if created with NewReference)
+	sharded         *ShardedClient // routes the reference to its shard (see resolve)
 }
 
 // NewReference creates a new Firebase DB reference at url passed as parameter.
@@ -84,10 +105,12 @@ func (r *Reference) PassKeepAlive(value bool) *Reference {
 }
 
 // Retry sets the retry policy for the Reference. When a references has the retry policy set,
-// then the library will retry the requests in case of failures.
+// then the library will retry the requests in case of failures. It is a shortcut for
+// WithRetryPolicy(&RetryPolicy{BackOff: backOff}).
 func (r *Reference) Retry(backOff *backoff.ExponentialBackOff) *Reference {
 	result := *r
 	result.retry = backOff
+	result.retryPolicy = nil
 	return &result
 }
 
@@ -117,6 +140,17 @@ func (r *Reference) withParam(key, value string) *Reference {
 	return &result
 }
 
+// withoutParams returns a reference to the same location without the query
+// parameters, except the namespace of the emulator.
+func (r *Reference) withoutParams() *Reference {
+	result := *r
+	result.url.RawQuery = ""
+	if ns := r.namespace(); ns != "" {
+		result.url.RawQuery = urllib.Values{"ns": {ns}}.Encode()
+	}
+	return &result
+}
+
 // withParam is a local function to add quoted query parameter to the URL. Integer are
 // returned as numbers and string are surrounded by double quotes.
 func (r *Reference) withQuotedParam(key string, value interface{}) *Reference {
@@ -152,13 +186,19 @@ func (r *Reference) Ref(path string) *Reference {
 }
 
 // RefFromUrl returns a reference to the root or the path specified in url.
-// err is set if the host of the url is not the same as the current database.
+// err is set if the host of the url is not the same as the current database (or
+// as one of the shards for a reference of a ShardedClient).
 //
 // See https://firebase.google.com/docs/reference/js/firebase.database.Database#refFromURL
 // for more details.
 func (r *Reference) RefFromUrl(url urllib.URL) *Reference {
+	if r.sharded != nil && r.sharded.hasHost(url.Host) {
+		return r.Ref(url.Path)
+	}
 	if r.url.Host != url.Host {
 		return r.withError(errors.New("The URL has not the same host as the current database"))
+	} else if ns := url.Query().Get("ns"); ns != "" && ns != r.namespace() {
+		return r.withError(errors.New("The URL has not the same namespace as the current database"))
 	} else {
 		return r.Ref(url.Path)
 	}
@@ -191,6 +231,29 @@ func (r *Reference) Auth(auth Authenticator) *Reference {
 	return &result
 }
 
+// AuthOverride makes the request run with the privileges of the given auth object instead of
+// the privileges of the authenticator. It is typically used by admin services that hold a
+// database secret or a service account token and that need to read or write "as" a specific
+// user, so that the security rules are enforced. The auth argument must marshal to a JSON object
+// (for example map[string]interface{}{"uid": "ada"}) or be nil to run the request as an
+// unauthenticated client.
+//
+// See https://firebase.google.com/docs/database/rest/auth
+// for more details.
+func (r *Reference) AuthOverride(auth interface{}) *Reference {
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return r.withError(errors.New(fmt.Sprintf("error encoding the auth override: %v", err)))
+	}
+	override := string(b)
+	if override != "null" && !strings.HasPrefix(override, "{") {
+		return r.withError(errors.New("The auth override must be a JSON object or null"))
+	}
+	result := *r
+	result.authOverride = &override
+	return &result
+}
+
 // Shallow is an advanced feature, designed to help you work with large datasets without
 // needing to download everything. Set this to true to limit the depth of the data returned
 // at a location. If the data at the location is a JSON primitive (string, number or boolean),
@@ -229,6 +292,54 @@ func (r *Reference) Export() *Reference {
 	return r.withParam("format", "export")
 }
 
+// MaxTimeout is the longest timeout accepted by the server for a read.
+const MaxTimeout = 15 * time.Minute
+
+// Timeout limits how long a read may take on the server side. If the read doesn't
+// complete in time, the request fails with a *TimeoutError. The timeout must be
+// between one millisecond and MaxTimeout.
+//
+// See https://firebase.google.com/docs/reference/rest/database/#section-param-timeout
+// for more details.
+func (r *Reference) Timeout(d time.Duration) *Reference {
+	if d < time.Millisecond || d > MaxTimeout {
+		return r.withError(errors.New(fmt.Sprintf("Invalid timeout %v (must be between 1ms and %v)", d, MaxTimeout)))
+	}
+	switch {
+	case d%time.Minute == 0:
+		return r.withParam("timeout", fmt.Sprintf("%dmin", d/time.Minute))
+	case d%time.Second == 0:
+		return r.withParam("timeout", fmt.Sprintf("%ds", d/time.Second))
+	default:
+		return r.withParam("timeout", fmt.Sprintf("%dms", (d+time.Millisecond-1)/time.Millisecond))
+	}
+}
+
+// WriteSizeLimit is the value of the writeSizeLimit parameter. The server rejects
+// the writes that would take longer than the target time of the limit.
+type WriteSizeLimit string
+
+const (
+	WriteSizeTiny      WriteSizeLimit = "tiny"   // target = 1s
+	WriteSizeSmall     WriteSizeLimit = "small"  // target = 10s
+	WriteSizeMedium    WriteSizeLimit = "medium" // target = 30s
+	WriteSizeLarge     WriteSizeLimit = "large"  // target = 60s
+	WriteSizeUnlimited WriteSizeLimit = "unlimited"
+)
+
+// WriteSizeLimit sets the maximum size of a write. A write exceeding the limit fails
+// with a *WriteTooBigError.
+//
+// See https://firebase.google.com/docs/reference/rest/database/#section-param-writesizelimit
+// for more details.
+func (r *Reference) WriteSizeLimit(limit WriteSizeLimit) *Reference {
+	switch limit {
+	case WriteSizeTiny, WriteSizeSmall, WriteSizeMedium, WriteSizeLarge, WriteSizeUnlimited:
+		return r.withParam("writeSizeLimit", string(limit))
+	}
+	return r.withError(errors.New(fmt.Sprintf("Invalid write size limit %q", limit)))
+}
+
 // Key returns the last part of the current path.
 // For example, "ada" is the key for https://sample-app.firebaseio.com/users/ada.
 //
@@ -273,10 +384,14 @@ func (r *Reference) Child(path string) *Reference {
 	return &result
 }
 
-// String returns the absolute URL for this location.
+// String returns the absolute URL for this location. The URL of a reference of a
+// ShardedClient is the URL on its shard.
 //
 // See https://firebase.google.com/docs/reference/js/firebase.database.Reference#toString
 // for more details.
 func (r *Reference) String() string {
+	if routed, err := r.resolve(); err == nil {
+		return routed.url.String()
+	}
 	return r.url.String()
 }
@@ -1,9 +1,13 @@
 package firebasedb
 
 import (
+	"errors"
 	"github.com/cenkalti/backoff"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestPassKeepAlive(t *testing.T) {
@@ -45,3 +49,89 @@ func TestRules(t *testing.T) {
 	assert.Equal(t, "/", r1.url.Path)
 	assert.Equal(t, "/.settings/rules", r2.url.Path)
 }
+
+func TestAuthOverride(t *testing.T) {
+	r1 := NewReference("https://domain.com/").Auth(Secret{Token: "secret"})
+	assert.NoError(t, r1.Error)
+	assert.Nil(t, r1.authOverride)
+
+	r2 := r1.AuthOverride(map[string]interface{}{"uid": "ada"})
+	assert.NoError(t, r2.Error)
+	assert.Nil(t, r1.authOverride)
+	v := r2.addAuth().url.Query()
+	assert.Equal(t, []string{"secret"}, v["auth"])
+	assert.Equal(t, []string{`{"uid":"ada"}`}, v["auth_variable_override"])
+
+	r3 := r1.AuthOverride(nil)
+	assert.NoError(t, r3.Error)
+	v = r3.addAuth().url.Query()
+	assert.Equal(t, []string{"null"}, v["auth_variable_override"])
+
+	r4 := r1.AuthOverride("ada")
+	assert.Error(t, r4.Error)
+}
+
+func TestTimeout(t *testing.T) {
+	r := NewReference("https://domain.com/")
+	for d, expected := range map[time.Duration]string{
+		2 * time.Minute:         "2min",
+		90 * time.Second:        "90s",
+		1500 * time.Millisecond: "1500ms",
+		time.Millisecond + 1:    "2ms",
+	} {
+		r2 := r.Timeout(d)
+		assert.NoError(t, r2.Error)
+		assert.Equal(t, expected, r2.url.Query().Get("timeout"))
+	}
+	assert.Error(t, r.Timeout(0).Error)
+	assert.Error(t, r.Timeout(16*time.Minute).Error)
+}
+
+func TestWriteSizeLimit(t *testing.T) {
+	r := NewReference("https://domain.com/")
+	r2 := r.WriteSizeLimit(WriteSizeSmall)
+	assert.NoError(t, r2.Error)
+	assert.Equal(t, "small", r2.url.Query().Get("writeSizeLimit"))
+	assert.Error(t, r.WriteSizeLimit("huge").Error)
+}
+
+func TestResponseErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/big.json":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "write too big"}`))
+		case "/slow.json":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "The query timed out"}`))
+		case "/large.json":
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		case "/invalid.json":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "Invalid data; the timeout is too large"}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "Permission denied"}`))
+		}
+	}))
+	defer server.Close()
+	db := NewReference(server.URL)
+
+	var responseError *ResponseError
+	err := db.Ref("big").WriteSizeLimit(WriteSizeTiny).Set(1)
+	assert.IsType(t, &WriteTooBigError{}, err)
+	if assert.True(t, errors.As(err, &responseError)) {
+		assert.Equal(t, http.StatusBadRequest, responseError.StatusCode)
+	}
+	assert.IsType(t, &WriteTooBigError{}, db.Ref("large").Set(1))
+	var v interface{}
+	err = db.Ref("slow").Timeout(time.Second).Value(&v)
+	assert.IsType(t, &TimeoutError{}, err)
+	assert.True(t, errors.As(err, &responseError))
+	assert.IsType(t, &ResponseError{}, db.Ref("invalid").Set(1)) // the message only contains the words
+	err = db.Ref("other").Value(&v)
+	if assert.IsType(t, &ResponseError{}, err) {
+		assert.Equal(t, http.StatusUnauthorized, err.(*ResponseError).StatusCode)
+		assert.Equal(t, "Permission denied", err.(*ResponseError).Message)
+	}
+}
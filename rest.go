@@ -23,14 +23,15 @@ package firebasedb
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	pathlib "path"
-
-	"github.com/taskcluster/httpbackoff"
+	"time"
 )
 
 // WithHttpClient sets a custom HTTP client for the REST requests. If set to nil (default),
@@ -43,11 +44,14 @@ func (r *Reference) WithHttpClient(c *http.Client) *Reference {
 
 // addAuth returns a new reference with authentication information (if available).
 func (r *Reference) addAuth() *Reference {
+	result := r
 	if r.auth != nil {
-		return r.withParam(r.auth.ParamName(), r.auth.String())
-	} else {
-		return r
+		result = result.withParam(r.auth.ParamName(), r.auth.String())
 	}
+	if r.authOverride != nil {
+		result = result.withParam("auth_variable_override", *r.authOverride)
+	}
+	return result
 }
 
 // jsonUrl is an internal function to build the URL for the REST API
@@ -63,21 +67,33 @@ func (r *Reference) jsonUrl() string {
 	return u.String()
 }
 
-// jsonReader returns a reader (io.Reader) on the JSON representation
-// of the value passed as parameter.
-func jsonReader(value interface{}) (io.Reader, error) {
-	b := new(bytes.Buffer)
-	enc := json.NewEncoder(b)
-	err := enc.Encode(value)
+// body returns a reader on the JSON representation of a value written to the
+// database. If update is true, the value is encoded for a PATCH request (see Update).
+func (r *Reference) body(value interface{}, update bool) (io.Reader, error) {
+	value = encodeTags(value, update)
+	if r.arraysAsObjects {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		var generic interface{}
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		if err = d.Decode(&generic); err != nil {
+			return nil, err
+		}
+		value = objectForm(generic)
+	}
+	b, err := r.getCodec().Marshal(value)
 	if err != nil {
 		return nil, err
 	}
-	return b, nil
+	return bytes.NewReader(b), nil
 }
 
 func (r *Reference) writeDebug(req *http.Request, response *http.Response) {
 	fmt.Fprintln(r.debug, "----- BEGIN DEBUG -----")
-	fmt.Fprintf(r.debug, "%v %v\n", req.Method, req.URL)
+	fmt.Fprintf(r.debug, "%v %v\n", req.Method, redactedURL(req.URL))
 	dbg := response.Header.Get("X-Firebase-Auth-Debug")
 	if dbg != "" {
 		fmt.Fprintf(r.debug, "X-Firebase-Auth-Debug: %v\n", dbg)
@@ -86,42 +102,107 @@ func (r *Reference) writeDebug(req *http.Request, response *http.Response) {
 }
 
 func (r *Reference) do(req *http.Request) (*http.Response, error) {
-	client := r.httpClient()
-	if r.retry == nil {
-		r.Attempts = 1
-		return client.Do(req)
-	} else {
-		backoffClient := httpbackoff.Client{r.retry}
-		resp, attempts, err := backoffClient.ClientDo(client, req)
-		r.Attempts = attempts
-		return resp, err
-	}
+	if r.metrics == nil {
+		return r.doAttempts(req)
+	}
+	start := time.Now()
+	resp, err := r.doAttempts(req)
+	m := RequestMetrics{Operation: operation(req), Attempts: r.Attempts, Latency: time.Since(start), Err: err}
+	if resp != nil {
+		m.Status = resp.StatusCode
+		if m.Err == nil && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+			m.Err = errors.New(resp.Status)
+		}
+	}
+	r.metrics.ObserveRequest(m)
+	return resp, err
 }
 
-// Value reads from the database and store the content in value. It gives an error
-// if it the request fails or if it can't decode the returned payload.
-func (r *Reference) Value(value interface{}) (err error) {
-	req, err := http.NewRequest("GET", r.addAuth().jsonUrl(), nil)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while building the request: %v", err))
+// send executes a request on the location of the reference and returns the response
+// if its status is successful. The caller must close the body of the response.
+func (r *Reference) send(ctx context.Context, method string, body io.Reader, header http.Header) (*http.Response, error) {
+	if r.sharded != nil {
+		routed, err := r.resolve()
+		if err != nil {
+			return nil, err
+		}
+		return routed.send(ctx, method, body, header)
+	}
+	if err := r.checkOpen(); err != nil {
+		return nil, err
 	}
+	var requestBody []byte
+	if r.logger != nil && r.logBodies > 0 && body != nil {
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error reading body: %v", err))
+		}
+		requestBody, body = b, bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.addAuth().jsonUrl(), body)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error while building the request: %v", err))
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	release := func() {}
+	if r.limiter != nil {
+		start := time.Now()
+		if release, err = r.limiter.Wait(ctx); err != nil {
+			return nil, errors.New(fmt.Sprintf("error while executing the request: %v", err))
+		}
+		if m, ok := r.metrics.(LimiterMetrics); ok {
+			m.ObserveWait(operation(req), time.Since(start))
+		}
+	}
+	entry := &requestLog{ref: r, req: req, start: time.Now(), requestBody: requestBody}
 	response, err := r.do(req)
+	entry.latency = time.Since(entry.start)
 	if err != nil {
-		return errors.New(fmt.Sprintf("error while executing the request: %v", err))
+		release()
+		err = errors.New(fmt.Sprintf("error while executing the request: %v", err))
+		if r.logger != nil {
+			entry.err = err
+			entry.log()
+		}
+		return nil, err
+	}
+	entry.status = response.StatusCode
+	if r.limiter != nil {
+		response.Body = &limitedBody{ReadCloser: response.Body, release: release}
+	}
+	if r.logger != nil {
+		response.Body = &loggedBody{ReadCloser: response.Body, entry: entry}
 	}
-	defer response.Body.Close()
 	if r.debug != nil {
 		r.writeDebug(req, response)
 	}
 	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return errors.New(fmt.Sprintf("error, response is : %v", response.Status))
-	}
-	d := json.NewDecoder(response.Body)
-	err = d.Decode(value)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error decoding the result: %v", err))
+		err = responseError(response)
+		entry.err = err
+		response.Body.Close()
+		return nil, err
 	}
-	return nil
+	return response, nil
+}
+
+// request executes an operation with the method on the reference. The value is
+// written (for the methods with a body) and the response is decoded into result
+// (if not nil).
+func (r *Reference) request(method string, value interface{}, result interface{}) (err error) {
+	ctx, span := r.startSpan(methodOperation(method))
+	op := r.newOperation(methodOperation(method), method, value, result)
+	err = r.invoke(ctx, op)
+	r.endSpan(span, op, err)
+	return err
+}
+
+// Value reads from the database and store the content in value. It gives an error
+// if it the request fails or if it can't decode the returned payload.
+func (r *Reference) Value(value interface{}) (err error) {
+	r.recordQuery()
+	return r.request("GET", nil, value)
 }
 
 // Set write data to the database location given by the Reference r.
@@ -130,56 +211,13 @@ func (r *Reference) Value(value interface{}) (err error) {
 // See https://firebase.google.com/docs/reference/js/firebase.database.Reference#set
 // for more details.
 func (r *Reference) Set(value interface{}) (err error) {
-	b, err := jsonReader(value)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error reading body: %v", err))
-	}
-	req, err := http.NewRequest("PUT", r.addAuth().jsonUrl(), b)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while building the request: %v", err))
-	}
-	response, err := r.do(req)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while executing the request: %v", err))
-	}
-	defer response.Body.Close()
-	if r.debug != nil {
-		r.writeDebug(req, response)
-	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return errors.New(fmt.Sprintf("error, response is : %v", response.Status))
-	}
-	return nil
+	return r.request("PUT", value, nil)
 }
 
 // SetWithResult does the same as the Set function and, additionally, stores the
 // resulting node in result.
 func (r *Reference) SetWithResult(value interface{}, result interface{}) (err error) {
-	b, err := jsonReader(value)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error reading body: %v", err))
-	}
-	req, err := http.NewRequest("PUT", r.addAuth().jsonUrl(), b)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while building the request: %v", err))
-	}
-	response, err := r.do(req)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while executing the request: %v", err))
-	}
-	defer response.Body.Close()
-	if r.debug != nil {
-		r.writeDebug(req, response)
-	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return errors.New(fmt.Sprintf("error, response is : %v", response.Status))
-	}
-	d := json.NewDecoder(response.Body)
-	err = d.Decode(result)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error decoding the result: %v", err))
-	}
-	return nil
+	return r.request("PUT", value, result)
 }
 
 // Update writes multiple values to the database at once. The "value" argument contains multiple
@@ -189,60 +227,18 @@ func (r *Reference) SetWithResult(value interface{}, result interface{}) (err er
 //
 // As opposed to the set() method, update() can be use to selectively update only the referenced properties
 // at the current location (instead of replacing all the child properties at the current location).
+// When value is a struct using firebase tags, only the fields that are not zero are written.
 //
 // See https://firebase.google.com/docs/reference/js/firebase.database.Reference#update
 // for more details.
 func (r *Reference) Update(value interface{}) (err error) {
-	b, err := jsonReader(value)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error reading body: %v", err))
-	}
-	req, err := http.NewRequest("PATCH", r.addAuth().jsonUrl(), b)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while building the request: %v", err))
-	}
-	response, err := r.do(req)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while executing the request: %v", err))
-	}
-	defer response.Body.Close()
-	if r.debug != nil {
-		r.writeDebug(req, response)
-	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return errors.New(fmt.Sprintf("error, response is : %v", response.Status))
-	}
-	return nil
+	return r.request("PATCH", value, nil)
 }
 
 // UpdateWithResult does the same as the Update function and, additionally, stores the
 // updated node in result.
 func (r *Reference) UpdateWithResult(value interface{}, result interface{}) (err error) {
-	b, err := jsonReader(value)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error reading body: %v", err))
-	}
-	req, err := http.NewRequest("PATCH", r.addAuth().jsonUrl(), b)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while building the request: %v", err))
-	}
-	response, err := r.do(req)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while executing the request: %v", err))
-	}
-	defer response.Body.Close()
-	if r.debug != nil {
-		r.writeDebug(req, response)
-	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return errors.New(fmt.Sprintf("error, response is : %v", response.Status))
-	}
-	d := json.NewDecoder(response.Body)
-	err = d.Decode(result)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error decoding the result: %v", err))
-	}
-	return nil
+	return r.request("PATCH", value, result)
 }
 
 // Push generates a new child location using a unique key and returns this key
@@ -251,37 +247,13 @@ func (r *Reference) UpdateWithResult(value interface{}, result interface{}) (err
 // See https://firebase.google.com/docs/reference/js/firebase.database.Reference#push
 // for more details.
 func (r *Reference) Push(value interface{}) (name string, err error) {
-	b, err := jsonReader(value)
-	if err != nil {
-		return "", errors.New(fmt.Sprintf("error reading body: %v", err))
-	}
-	req, err := http.NewRequest("POST", r.addAuth().jsonUrl(), b)
-	if err != nil {
-		return "", errors.New(fmt.Sprintf("error while building the request: %v", err))
-	}
-	response, err := r.do(req)
-	if err != nil {
-		return "", errors.New(fmt.Sprintf("error while executing the request: %v", err))
-
+	var result struct {
+		Name string `json:"name"`
 	}
-	defer response.Body.Close()
-	if r.debug != nil {
-		r.writeDebug(req, response)
-	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return "", errors.New(fmt.Sprintf("error, response is : %v", response.Status))
-	}
-	result := map[string]string{}
-	d := json.NewDecoder(response.Body)
-	err = d.Decode(&result)
-	if err != nil {
+	if err = r.request("POST", value, &result); err != nil {
 		return "", err
 	}
-	if name, ok := result["name"]; ok {
-		return name, nil
-	} else {
-		return "", nil
-	}
+	return result.Name, nil
 }
 
 // Remove deletes the data at the database location given by the reference r.
@@ -290,20 +262,5 @@ func (r *Reference) Push(value interface{}) (name string, err error) {
 // See https://firebase.google.com/docs/reference/js/firebase.database.Reference#remove
 // for more details.
 func (r *Reference) Remove() (err error) {
-	req, err := http.NewRequest("DELETE", r.addAuth().jsonUrl(), nil)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while building the request: %v", err))
-	}
-	response, err := r.do(req)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error while executing the request: %v", err))
-	}
-	defer response.Body.Close()
-	if r.debug != nil {
-		r.writeDebug(req, response)
-	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return errors.New(fmt.Sprintf("error, response is : %v", response.Status))
-	}
-	return nil
+	return r.request("DELETE", nil, nil)
 }
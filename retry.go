@@ -0,0 +1,209 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// RetryClass is the class of a failure, as returned by a RetryPolicy classifier.
+type RetryClass int
+
+const (
+	// NoRetry is returned for the successful requests and the permanent failures.
+	NoRetry RetryClass = iota
+	// RetryTransport is returned when no response was received (network errors, ...).
+	// The request may have been executed by the server.
+	RetryTransport
+	// RetryServer is returned for the server errors (5xx). The request may have been
+	// executed by the server.
+	RetryServer
+	// RetryThrottled is returned when the server rejected the request because of a rate
+	// limit (429). The request is retried after the delay given by Retry-After if it
+	// is longer than the delay of the back off.
+	RetryThrottled
+	// RetryAuth is returned when the credentials were rejected (401). The request is
+	// retried once after renewing the credentials.
+	RetryAuth
+)
+
+// RetryPolicy controls how the failed requests are retried. The failures are classified
+// by Classify (DefaultClassifier if nil). The requests that were rejected by the server
+// (RetryThrottled and RetryAuth) are always retried, but the requests that may have been
+// executed (RetryTransport and RetryServer) are only retried if they are idempotent: the
+// POST requests (Push) are not retried unless RetryPost is true, because this could
+// create duplicated children. Use SafePush to push values with retries.
+type RetryPolicy struct {
+	// BackOff gives the delays between the attempts. It is copied and reset for
+	// each request. If nil, backoff.NewExponentialBackOff() is used.
+	BackOff *backoff.ExponentialBackOff
+	// MaxAttempts is the maximum number of attempts (0 means no limit other than
+	// the MaxElapsedTime of BackOff).
+	MaxAttempts int
+	// RetryPost allows the POST requests to be retried after a transport or server error.
+	RetryPost bool
+	// Classify returns the class of a failure. resp is nil if err is not nil.
+	Classify func(resp *http.Response, err error) RetryClass
+}
+
+// DefaultClassifier classifies the transport errors, the 5xx, 429 and 401 responses.
+func DefaultClassifier(resp *http.Response, err error) RetryClass {
+	switch {
+	case err != nil:
+		return RetryTransport
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return RetryThrottled
+	case resp.StatusCode == http.StatusUnauthorized:
+		return RetryAuth
+	case resp.StatusCode >= 500:
+		return RetryServer
+	}
+	return NoRetry
+}
+
+// WithRetryPolicy sets the retry policy of the reference. If policy is nil, the
+// requests are not retried.
+func (r *Reference) WithRetryPolicy(policy *RetryPolicy) *Reference {
+	result := *r
+	result.retryPolicy = policy
+	result.retry = nil
+	if policy != nil {
+		result.retry = policy.BackOff
+	}
+	return &result
+}
+
+// getRetryPolicy returns the retry policy of the reference or nil.
+func (r *Reference) getRetryPolicy() *RetryPolicy {
+	if r.retryPolicy != nil {
+		return r.retryPolicy
+	}
+	if r.retry != nil {
+		return &RetryPolicy{BackOff: r.retry}
+	}
+	return nil
+}
+
+// retryAfter returns the delay requested by the Retry-After header of a response.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// nextAttempt prepares the request for the next attempt.
+func nextAttempt(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	return next, nil
+}
+
+// doAttempts executes the request and retries it according to the retry policy.
+func (r *Reference) doAttempts(req *http.Request) (*http.Response, error) {
+	client := r.httpClient()
+	policy := r.getRetryPolicy()
+	r.Attempts = 1
+	if policy == nil {
+		return client.Do(req)
+	}
+	classify := policy.Classify
+	if classify == nil {
+		classify = DefaultClassifier
+	}
+	var b backoff.ExponentialBackOff
+	if policy.BackOff != nil {
+		b = *policy.BackOff
+	} else {
+		b = *backoff.NewExponentialBackOff()
+	}
+	b.Reset()
+	renewed := false
+	for {
+		resp, err := client.Do(req)
+		class := classify(resp, err)
+		var delay, requested time.Duration
+		switch class {
+		case NoRetry:
+			return resp, err
+		case RetryTransport, RetryServer:
+			if req.Method == "POST" && !policy.RetryPost {
+				return resp, err
+			}
+		case RetryAuth:
+			if renewed || r.auth == nil || r.auth.Renew() != nil {
+				return resp, err
+			}
+			renewed = true
+			q := req.URL.Query()
+			q.Set(r.auth.ParamName(), r.auth.String())
+			req.URL.RawQuery = q.Encode()
+		case RetryThrottled:
+			requested, _ = retryAfter(resp)
+		}
+		if class != RetryAuth {
+			// the back off is advanced even when the server sets the delay, so that
+			// MaxElapsedTime also ends the retries of a throttled request
+			if delay = b.NextBackOff(); delay == backoff.Stop {
+				return resp, err
+			}
+			if requested > delay {
+				delay = requested
+			}
+		}
+		if policy.MaxAttempts > 0 && r.Attempts >= policy.MaxAttempts {
+			return resp, err
+		}
+		next, nextErr := nextAttempt(req)
+		if nextErr != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if r.limiter != nil { // each attempt counts for the rate limit
+			if err := r.limiter.take(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+		req = next
+		r.Attempts++
+	}
+}
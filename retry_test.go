@@ -0,0 +1,190 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/stretchr/testify/assert"
+)
+
+func fastBackOff() *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = time.Millisecond
+	b.MaxInterval = time.Millisecond
+	return b
+}
+
+type renewingAuth struct {
+	token string
+}
+
+func (a *renewingAuth) ParamName() string { return "auth" }
+func (a *renewingAuth) String() string    { return a.token }
+func (a *renewingAuth) Renew() error {
+	a.token = "fresh"
+	return nil
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	failures := map[string][]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, req.Method+" "+req.URL.Path+" "+req.URL.Query().Get("auth")+" "+string(b))
+		if req.URL.Query().Get("auth") == "expired" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if codes := failures[req.URL.Path]; len(codes) > 0 {
+			failures[req.URL.Path] = codes[1:]
+			if codes[0] == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "0")
+			}
+			w.WriteHeader(codes[0])
+			return
+		}
+		w.Write([]byte(`{"name":"-K1"}`))
+	}))
+	defer server.Close()
+	db := NewReference(server.URL).WithRetryPolicy(&RetryPolicy{BackOff: fastBackOff(), MaxAttempts: 3})
+
+	failures["/a.json"] = []int{503, 429}
+	a := db.Ref("a")
+	assert.NoError(t, a.Set(1))
+	assert.Equal(t, 3, a.Attempts)
+
+	failures["/b.json"] = []int{503, 503, 503, 503}
+	b := db.Ref("b")
+	assert.Error(t, b.Set(1))
+	assert.Equal(t, 3, b.Attempts)
+
+	failures["/c.json"] = []int{500}
+	c := db.Ref("c")
+	_, err := c.Push(1)
+	assert.Error(t, err)
+	assert.Equal(t, 1, c.Attempts)
+
+	failures["/d.json"] = []int{429}
+	d := db.Ref("d")
+	_, err = d.Push(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d.Attempts)
+
+	failures["/e.json"] = []int{400}
+	e := db.Ref("e")
+	assert.Error(t, e.Set(1))
+	assert.Equal(t, 1, e.Attempts)
+
+	f := db.Ref("f").Auth(&renewingAuth{token: "expired"})
+	assert.NoError(t, f.Set(2))
+	assert.Equal(t, 2, f.Attempts)
+
+	assert.Equal(t, []string{
+		"PUT /a.json  1", "PUT /a.json  1", "PUT /a.json  1",
+		"PUT /b.json  1", "PUT /b.json  1", "PUT /b.json  1",
+		"POST /c.json  1",
+		"POST /d.json  1", "POST /d.json  1",
+		"PUT /e.json  1",
+		"PUT /f.json expired 2", "PUT /f.json fresh 2",
+	}, requests)
+}
+
+func TestRetryDisabled(t *testing.T) {
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		count++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	ref := NewReference(server.URL).Retry(fastBackOff()).WithRetryPolicy(nil)
+	assert.Error(t, ref.Set(1))
+	assert.Equal(t, 1, count)
+}
+
+func TestNewPushID(t *testing.T) {
+	var ids []string
+	for i := 0; i < 1000; i++ {
+		ids = append(ids, NewPushID())
+	}
+	assert.True(t, sort.StringsAreSorted(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		assert.Equal(t, 20, len(id))
+		assert.False(t, seen[id])
+		seen[id] = true
+		assert.Equal(t, "", strings.Trim(id, pushChars))
+	}
+}
+
+func TestSafePush(t *testing.T) {
+	var paths []string
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("1"))
+	}))
+	defer server.Close()
+	ref := NewReference(server.URL).Ref("list").Retry(fastBackOff())
+	name, err := ref.SafePush(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PUT /list/" + name + ".json", "PUT /list/" + name + ".json"}, paths)
+}
+
+func TestRetryThrottledStops(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	b := fastBackOff()
+	b.MaxElapsedTime = 500 * time.Millisecond
+	ref := NewReference(server.URL).WithRetryPolicy(&RetryPolicy{BackOff: b})
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() { done <- ref.Set(1) }()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the throttled request is retried forever")
+	}
+	assert.True(t, time.Since(start) >= time.Second, "Retry-After is honoured")
+	mu.Lock()
+	assert.Equal(t, 2, count)
+	mu.Unlock()
+}
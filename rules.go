@@ -0,0 +1,356 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.google.com/docs/database/security
+// https://firebase.google.com/docs/reference/rest/database#section-rules
+
+package firebasedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// RuleNode represents one location of a security rules document. The expressions
+// are stored as strings; the boolean constants are stored as "true" and "false".
+// An empty string means that the rule is not defined at this location.
+type RuleNode struct {
+	Read     string
+	Write    string
+	Validate string
+	IndexOn  []string
+	Children map[string]*RuleNode // the key of a wildcard child starts with "$"
+}
+
+// Rules is a parsed security rules document. Raw holds the document as it was
+// read (including the comments) and Root is the node under the "rules" key.
+type Rules struct {
+	Raw  []byte
+	Root *RuleNode
+}
+
+// RuleDiff is a difference between two rules documents. Path is the location
+// of the rule (with the wildcards) and Rule is the name of the rule (".read",
+// ".write", ".validate" or ".indexOn"). An empty value means that the rule is
+// not defined in the corresponding document.
+type RuleDiff struct {
+	Path     string
+	Rule     string
+	Local    string
+	Deployed string
+}
+
+// stripComments removes the "//" and "/* */" comments from a rules document
+// and returns plain JSON. Comment markers inside of strings are preserved.
+func stripComments(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out.WriteByte(data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+			out.WriteByte(c)
+		} else if c == '/' && i+1 < len(data) && data[i+1] == '/' {
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out.WriteByte('\n')
+			}
+		} else if c == '/' && i+1 < len(data) && data[i+1] == '*' {
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, errors.New("Unterminated comment in rules")
+			}
+			// keep the new lines to preserve the line numbers
+			out.Write(bytes.Repeat([]byte("\n"), bytes.Count(data[i:i+2+end], []byte("\n"))))
+			out.WriteByte(' ')
+			i += end + 3
+		} else {
+			out.WriteByte(c)
+		}
+	}
+	if inString {
+		return nil, errors.New("Unterminated string in rules")
+	}
+	return out.Bytes(), nil
+}
+
+// parseExpression decodes the value of a .read, .write or .validate rule and
+// checks the syntax of the expression.
+func parseExpression(path string, raw json.RawMessage) (string, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return fmt.Sprintf("%v", b), nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", errors.New(fmt.Sprintf("%v: rule must be a boolean or a string", path))
+	}
+	if strings.TrimSpace(s) == "" {
+		return "", errors.New(fmt.Sprintf("%v: empty expression", path))
+	}
+	if _, err := compileRule(s); err != nil {
+		return "", errors.New(fmt.Sprintf("%v: %v", path, err))
+	}
+	return s, nil
+}
+
+// parseRuleNode decodes a location of the rules document.
+func parseRuleNode(path string, raw json.RawMessage) (*RuleNode, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil, errors.New(fmt.Sprintf("%v: expected an object", path))
+	}
+	node := &RuleNode{Children: map[string]*RuleNode{}}
+	prefix := strings.TrimSuffix(path, "/")
+	wildcard := ""
+	var err error
+	for key, value := range fields {
+		switch {
+		case key == ".read":
+			node.Read, err = parseExpression(prefix+"/.read", value)
+		case key == ".write":
+			node.Write, err = parseExpression(prefix+"/.write", value)
+		case key == ".validate":
+			node.Validate, err = parseExpression(prefix+"/.validate", value)
+		case key == ".indexOn":
+			var single string
+			if json.Unmarshal(value, &single) == nil {
+				node.IndexOn = []string{single}
+			} else if json.Unmarshal(value, &node.IndexOn) != nil {
+				err = errors.New(fmt.Sprintf("%v/.indexOn: expected a string or an array of strings", prefix))
+			}
+		case strings.HasPrefix(key, "."):
+			err = errors.New(fmt.Sprintf("%v: unknown rule %v", path, key))
+		default:
+			if strings.HasPrefix(key, "$") {
+				if wildcard != "" {
+					err = errors.New(fmt.Sprintf("%v: more than one wildcard (%v and %v)", path, wildcard, key))
+					break
+				}
+				wildcard = key
+			}
+			node.Children[key], err = parseRuleNode(prefix+"/"+key, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// ParseRules parses a security rules document. The document is JSON with optional
+// "//" and "/* */" comments and must have a top level "rules" key. ParseRules
+// checks the structure of the document and returns an error describing the first
+// problem found.
+func ParseRules(data []byte) (*Rules, error) {
+	plain, err := stripComments(data)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]json.RawMessage
+	if err = json.Unmarshal(plain, &doc); err != nil {
+		return nil, errors.New(fmt.Sprintf("error decoding the rules: %v", err))
+	}
+	raw, ok := doc["rules"]
+	if !ok {
+		return nil, errors.New("The rules document has no \"rules\" key")
+	}
+	for key := range doc {
+		if key != "rules" {
+			return nil, errors.New(fmt.Sprintf("Unknown top level key %v in rules", key))
+		}
+	}
+	root, err := parseRuleNode("/", raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Rules{Raw: data, Root: root}, nil
+}
+
+// ValidateRules checks the syntax of a rules document without sending it to the server.
+func ValidateRules(data []byte) error {
+	_, err := ParseRules(data)
+	return err
+}
+
+// ParseRulesFile reads and parses the rules document stored in filename.
+func ParseRulesFile(filename string) (*Rules, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(data)
+}
+
+// expressionValue returns the JSON representation of an expression.
+func expressionValue(expr string) interface{} {
+	switch expr {
+	case "true":
+		return true
+	case "false":
+		return false
+	default:
+		return expr
+	}
+}
+
+// MarshalJSON encodes the node as it would appear in a rules document.
+func (n *RuleNode) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{}
+	if n.Read != "" {
+		m[".read"] = expressionValue(n.Read)
+	}
+	if n.Write != "" {
+		m[".write"] = expressionValue(n.Write)
+	}
+	if n.Validate != "" {
+		m[".validate"] = expressionValue(n.Validate)
+	}
+	if len(n.IndexOn) > 0 {
+		m[".indexOn"] = n.IndexOn
+	}
+	for k, v := range n.Children {
+		m[k] = v
+	}
+	return json.Marshal(m)
+}
+
+// MarshalJSON encodes the parsed rules (without the comments).
+func (rules *Rules) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]*RuleNode{"rules": rules.Root})
+}
+
+// flatten stores all rules of the node and its descendants in m.
+func (n *RuleNode) flatten(path string, m map[string]string) {
+	rules := map[string]string{".read": n.Read, ".write": n.Write, ".validate": n.Validate}
+	if len(n.IndexOn) > 0 {
+		index := append([]string{}, n.IndexOn...)
+		sort.Strings(index)
+		rules[".indexOn"] = strings.Join(index, ",")
+	}
+	for k, v := range rules {
+		if v != "" {
+			m[path+"\x00"+k] = v
+		}
+	}
+	for k, child := range n.Children {
+		child.flatten(strings.TrimSuffix(path, "/")+"/"+k, m)
+	}
+}
+
+// DiffRules compares two rules documents and returns the differences sorted by path.
+// The expressions are compared as strings.
+func DiffRules(local, deployed *Rules) []RuleDiff {
+	l := map[string]string{}
+	d := map[string]string{}
+	local.Root.flatten("/", l)
+	deployed.Root.flatten("/", d)
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]string{l, d} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	var result []RuleDiff
+	for _, k := range keys {
+		if l[k] != d[k] {
+			parts := strings.SplitN(k, "\x00", 2)
+			result = append(result, RuleDiff{Path: parts[0], Rule: parts[1], Local: l[k], Deployed: d[k]})
+		}
+	}
+	return result
+}
+
+// RawRules reads the security rules of the database and returns the document
+// as it is stored on the server (including the comments). This requires the
+// reference to be authenticated with the database secret or an admin token.
+//
+// See https://firebase.google.com/docs/reference/rest/database#section-rules
+// for more details.
+func (r *Reference) RawRules() ([]byte, error) {
+	response, err := r.Rules().send(r.Context(), "GET", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error reading the rules: %v", err))
+	}
+	return data, nil
+}
+
+// GetRules reads and parses the security rules of the database.
+func (r *Reference) GetRules() (*Rules, error) {
+	data, err := r.RawRules()
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(data)
+}
+
+// SetRules validates the rules document locally and, if it is valid, uploads it
+// to the server. The document is sent as is, so the comments are preserved.
+func (r *Reference) SetRules(data []byte) error {
+	if err := ValidateRules(data); err != nil {
+		return err
+	}
+	response, err := r.Rules().send(r.Context(), "PUT", bytes.NewReader(data), nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// SetRulesFromFile uploads the rules document stored in filename.
+func (r *Reference) SetRulesFromFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return r.SetRules(data)
+}
+
+// DiffDeployedRules compares the local rules with the rules deployed on the server.
+func (r *Reference) DiffDeployedRules(local *Rules) ([]RuleDiff, error) {
+	deployed, err := r.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	return DiffRules(local, deployed), nil
+}
@@ -0,0 +1,505 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.google.com/docs/database/security/core-syntax
+// https://firebase.google.com/docs/reference/security/database
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ruleSnapshot is the value of the root, data and newData variables. It points
+// to a location in a (generic) JSON tree.
+type ruleSnapshot struct {
+	tree interface{}
+	path []string
+}
+
+// ruleContext holds the variables available to an expression.
+type ruleContext struct {
+	auth    interface{}
+	now     float64
+	root    *ruleSnapshot
+	data    *ruleSnapshot
+	newData *ruleSnapshot
+	vars    map[string]interface{}
+}
+
+// compiledRule is a location of a rules document with its compiled expressions.
+type compiledRule struct {
+	read, write, validate ruleExpr
+	children              map[string]*compiledRule
+	wildcard              string
+}
+
+// Evaluator evaluates security rules locally. It holds the rules and a copy
+// of the database content and answers questions like "would this user be allowed
+// to write this value at this location?". It can be used standalone in unit tests
+// or plugged into a fake server with the Check method.
+//
+// Errors that occur while evaluating an expression (for example reading a property
+// of a null auth) make the rule fail, as they do on the server.
+type Evaluator struct {
+	root *compiledRule
+	data interface{}
+	Now  func() time.Time // defaults to time.Now
+}
+
+// compileRuleNode compiles all expressions of a rules node and of its children.
+func compileRuleNode(path string, n *RuleNode) (*compiledRule, error) {
+	c := &compiledRule{children: map[string]*compiledRule{}}
+	prefix := strings.TrimSuffix(path, "/")
+	for _, rule := range []struct {
+		name string
+		src  string
+		dst  *ruleExpr
+	}{{".read", n.Read, &c.read}, {".write", n.Write, &c.write}, {".validate", n.Validate, &c.validate}} {
+		if rule.src == "" {
+			continue
+		}
+		expr, err := compileRule(rule.src)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%v/%v: %v", prefix, rule.name, err))
+		}
+		*rule.dst = expr
+	}
+	for key, child := range n.Children {
+		compiled, err := compileRuleNode(prefix+"/"+key, child)
+		if err != nil {
+			return nil, err
+		}
+		c.children[key] = compiled
+		if strings.HasPrefix(key, "$") {
+			c.wildcard = key
+		}
+	}
+	return c, nil
+}
+
+// child returns the rules for the child key and the name of the wildcard
+// variable if the key matches a wildcard.
+func (c *compiledRule) child(key string) (*compiledRule, string) {
+	if child, ok := c.children[key]; ok {
+		return child, ""
+	}
+	if c.wildcard != "" {
+		return c.children[c.wildcard], c.wildcard
+	}
+	return nil, ""
+}
+
+// NewEvaluator returns an evaluator for the given rules and an empty database.
+// It returns an error if an expression can't be compiled.
+func NewEvaluator(rules *Rules) (*Evaluator, error) {
+	root, err := compileRuleNode("/", rules.Root)
+	if err != nil {
+		return nil, err
+	}
+	return &Evaluator{root: root}, nil
+}
+
+// UserAuth returns the auth variable of a user authenticated with the given uid.
+func UserAuth(uid string) map[string]interface{} {
+	return map[string]interface{}{
+		"uid":      uid,
+		"provider": "custom",
+		"token":    map[string]interface{}{"sub": uid},
+	}
+}
+
+// normalizeData converts a value into the generic JSON tree stored by the
+// database: arrays become objects and null or empty children are removed.
+func normalizeData(value interface{}) (interface{}, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err = json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	return pruneData(generic), nil
+}
+
+func pruneData(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		m := map[string]interface{}{}
+		for i, child := range v {
+			m[strconv.Itoa(i)] = child
+		}
+		return pruneData(m)
+	case map[string]interface{}:
+		for k, child := range v {
+			if c := pruneData(child); c == nil {
+				delete(v, k)
+			} else {
+				v[k] = c
+			}
+		}
+		if len(v) == 0 {
+			return nil
+		}
+	}
+	return value
+}
+
+// splitPath splits a database path into its components.
+func splitPath(path string) []string {
+	var result []string
+	for _, p := range strings.Split(path, "/") {
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// getNode returns the value stored at path in tree or nil.
+func getNode(tree interface{}, path []string) interface{} {
+	for _, key := range path {
+		m, ok := tree.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		tree = m[key]
+	}
+	return tree
+}
+
+// setNode returns a copy of tree with value stored at path. The original tree is not modified.
+func setNode(tree interface{}, path []string, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	m := map[string]interface{}{}
+	if old, ok := tree.(map[string]interface{}); ok {
+		for k, v := range old {
+			m[k] = v
+		}
+	}
+	if child := setNode(m[path[0]], path[1:], value); child == nil {
+		delete(m, path[0])
+	} else {
+		m[path[0]] = child
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// SetData replaces the content of the database used to evaluate the rules.
+func (e *Evaluator) SetData(data interface{}) error {
+	d, err := normalizeData(data)
+	if err != nil {
+		return err
+	}
+	e.data = d
+	return nil
+}
+
+// Data returns the current content of the database as a generic JSON tree.
+func (e *Evaluator) Data() interface{} {
+	return e.data
+}
+
+// context returns a new evaluation context.
+func (e *Evaluator) context(auth map[string]interface{}, newRoot interface{}) *ruleContext {
+	now := time.Now
+	if e.Now != nil {
+		now = e.Now
+	}
+	ctx := &ruleContext{
+		now:     float64(now().UnixNano() / int64(time.Millisecond)),
+		root:    &ruleSnapshot{tree: e.data},
+		data:    &ruleSnapshot{tree: e.data},
+		newData: &ruleSnapshot{tree: newRoot},
+		vars:    map[string]interface{}{},
+	}
+	if auth != nil {
+		a, err := normalizeData(auth)
+		if err == nil && a != nil {
+			ctx.auth = a
+		}
+	}
+	return ctx
+}
+
+// at returns a copy of the context for the location path.
+func (ctx *ruleContext) at(path []string, vars map[string]interface{}) *ruleContext {
+	result := *ctx
+	result.data = &ruleSnapshot{tree: ctx.data.tree, path: path}
+	result.newData = &ruleSnapshot{tree: ctx.newData.tree, path: path}
+	result.vars = vars
+	return &result
+}
+
+// check evaluates a rule and returns true only if the result is the boolean true.
+func (ctx *ruleContext) check(expr ruleExpr) bool {
+	if expr == nil {
+		return false
+	}
+	v, err := expr.eval(ctx)
+	return err == nil && v == true
+}
+
+// withVar returns a copy of vars with the wildcard variable name set to value.
+func withVar(vars map[string]interface{}, name string, value string) map[string]interface{} {
+	if name == "" {
+		return vars
+	}
+	result := map[string]interface{}{name: value}
+	for k, v := range vars {
+		result[k] = v
+	}
+	return result
+}
+
+// granted walks the rules from the root to path and returns true if one of the
+// rules selected by pick grants the access. The rules cascade: a rule granting
+// the access at a location can't be revoked deeper in the tree.
+func (e *Evaluator) granted(ctx *ruleContext, path []string, pick func(*compiledRule) ruleExpr) bool {
+	node := e.root
+	vars := map[string]interface{}{}
+	for level := 0; ; level++ {
+		if ctx.at(path[:level], vars).check(pick(node)) {
+			return true
+		}
+		if level == len(path) {
+			return false
+		}
+		var wildcard string
+		if node, wildcard = node.child(path[level]); node == nil {
+			return false
+		}
+		vars = withVar(vars, wildcard, path[level])
+	}
+}
+
+// validTree evaluates the .validate rules of node and of its descendants for the new data.
+func (e *Evaluator) validTree(ctx *ruleContext, node *compiledRule, path []string, vars map[string]interface{}, done map[string]bool) bool {
+	if getNode(ctx.newData.tree, path) == nil {
+		return true // .validate rules are not evaluated for deleted data
+	}
+	key := strings.Join(path, "/")
+	if !done[key] && node.validate != nil && !ctx.at(path, vars).check(node.validate) {
+		return false
+	}
+	done[key] = true
+	children, _ := getNode(ctx.newData.tree, path).(map[string]interface{})
+	for k := range children {
+		child, wildcard := node.child(k)
+		if child == nil {
+			continue
+		}
+		if !e.validTree(ctx, child, append(append([]string{}, path...), k), withVar(vars, wildcard, k), done) {
+			return false
+		}
+	}
+	return true
+}
+
+// valid evaluates the .validate rules on the path to the written location
+// and in the written data.
+func (e *Evaluator) valid(ctx *ruleContext, path []string, done map[string]bool) bool {
+	node := e.root
+	vars := map[string]interface{}{}
+	for level := 0; level < len(path); level++ {
+		key := strings.Join(path[:level], "/")
+		if !done[key] && node.validate != nil && getNode(ctx.newData.tree, path[:level]) != nil {
+			if !ctx.at(path[:level], vars).check(node.validate) {
+				return false
+			}
+			done[key] = true
+		}
+		var wildcard string
+		if node, wildcard = node.child(path[level]); node == nil {
+			return true
+		}
+		vars = withVar(vars, wildcard, path[level])
+	}
+	return e.validTree(ctx, node, path, vars, done)
+}
+
+// canWrite checks a set of writes (absolute path to new value) that are applied atomically.
+func (e *Evaluator) canWrite(auth map[string]interface{}, writes map[string]interface{}) (bool, error) {
+	newRoot := e.data
+	for p, value := range writes {
+		v, err := normalizeData(value)
+		if err != nil {
+			return false, err
+		}
+		newRoot = setNode(newRoot, splitPath(p), v)
+	}
+	ctx := e.context(auth, newRoot)
+	for p := range writes {
+		if !e.granted(ctx, splitPath(p), func(n *compiledRule) ruleExpr { return n.write }) {
+			return false, nil
+		}
+	}
+	done := map[string]bool{}
+	for p := range writes {
+		if !e.valid(ctx, splitPath(p), done) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CanRead returns true if a client authenticated with auth (nil for an
+// unauthenticated client) is allowed to read the data at path.
+func (e *Evaluator) CanRead(auth map[string]interface{}, path string) bool {
+	ctx := e.context(auth, e.data)
+	return e.granted(ctx, splitPath(path), func(n *compiledRule) ruleExpr { return n.read })
+}
+
+// CanSet returns true if the client is allowed to write value at path, as with
+// Reference.Set. A nil value is a removal.
+func (e *Evaluator) CanSet(auth map[string]interface{}, path string, value interface{}) (bool, error) {
+	return e.canWrite(auth, map[string]interface{}{path: value})
+}
+
+// CanUpdate returns true if the client is allowed to update the children of path
+// with values, as with Reference.Update. The keys of values can be relative paths.
+func (e *Evaluator) CanUpdate(auth map[string]interface{}, path string, values map[string]interface{}) (bool, error) {
+	writes := map[string]interface{}{}
+	for k, v := range values {
+		writes[strings.TrimSuffix(path, "/")+"/"+k] = v
+	}
+	return e.canWrite(auth, writes)
+}
+
+// Check evaluates a REST request (method GET, PUT, PATCH, POST or DELETE on path with
+// the JSON body) and returns true if the request would be allowed. It is meant to
+// be used by fake servers.
+func (e *Evaluator) Check(auth map[string]interface{}, method string, path string, body []byte) (bool, error) {
+	var value interface{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &value); err != nil {
+			return false, errors.New(fmt.Sprintf("error decoding the body: %v", err))
+		}
+	}
+	switch method {
+	case "GET":
+		return e.CanRead(auth, path), nil
+	case "PUT":
+		return e.CanSet(auth, path, value)
+	case "DELETE":
+		return e.CanSet(auth, path, nil)
+	case "POST":
+		key := fmt.Sprintf("-%019d", time.Now().UnixNano())
+		return e.CanSet(auth, strings.TrimSuffix(path, "/")+"/"+key, value)
+	case "PATCH":
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return false, errors.New("The body of a PATCH request must be an object")
+		}
+		return e.CanUpdate(auth, path, values)
+	}
+	return false, errors.New(fmt.Sprintf("Unsupported method %v", method))
+}
+
+// node returns the value at the location of the snapshot.
+func (s *ruleSnapshot) node() interface{} {
+	return getNode(s.tree, s.path)
+}
+
+// val returns the value of the snapshot without the priority.
+func (s *ruleSnapshot) val() interface{} {
+	v := s.node()
+	if m, ok := v.(map[string]interface{}); ok {
+		if value, ok := m[".value"]; ok {
+			return value
+		}
+		if _, ok := m[".priority"]; ok {
+			result := map[string]interface{}{}
+			for k, child := range m {
+				if k != ".priority" {
+					result[k] = child
+				}
+			}
+			return result
+		}
+	}
+	return v
+}
+
+// call executes the snapshot method name.
+func (s *ruleSnapshot) call(name string, args []interface{}) (interface{}, error) {
+	switch name {
+	case "val":
+		return s.val(), nil
+	case "exists":
+		return s.node() != nil, nil
+	case "isNumber", "isString", "isBoolean":
+		switch s.val().(type) {
+		case float64:
+			return name == "isNumber", nil
+		case string:
+			return name == "isString", nil
+		case bool:
+			return name == "isBoolean", nil
+		}
+		return false, nil
+	case "getPriority":
+		if m, ok := s.node().(map[string]interface{}); ok {
+			return m[".priority"], nil
+		}
+		return nil, nil
+	case "parent":
+		if len(s.path) == 0 {
+			return nil, errors.New("The root has no parent")
+		}
+		return &ruleSnapshot{tree: s.tree, path: s.path[:len(s.path)-1]}, nil
+	case "child", "hasChild":
+		a, err := stringArgs(name, args, 1)
+		if err != nil {
+			return nil, err
+		}
+		child := &ruleSnapshot{tree: s.tree, path: append(append([]string{}, s.path...), splitPath(a[0])...)}
+		if name == "child" {
+			return child, nil
+		}
+		return child.node() != nil, nil
+	case "hasChildren":
+		m, _ := s.node().(map[string]interface{})
+		if len(args) == 0 {
+			return len(m) > 0, nil
+		}
+		keys, ok := args[0].([]interface{})
+		if len(args) != 1 || !ok {
+			return nil, errors.New("hasChildren expects an array of keys")
+		}
+		for _, k := range keys {
+			key, ok := k.(string)
+			if !ok {
+				return nil, errors.New("hasChildren expects an array of strings")
+			}
+			if getNode(m, splitPath(key)) == nil {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown method %v", name))
+}
@@ -0,0 +1,175 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const evaluatorRules = `{
+  "rules": {
+    "public": { ".read": true },
+    "users": {
+      "$uid": {
+        ".read": "auth != null && auth.uid == $uid",
+        ".write": "auth != null && auth.uid == $uid && (!data.exists() || data.child('locked').val() != true)",
+        ".validate": "newData.hasChildren(['name'])",
+        "name": { ".validate": "newData.isString() && newData.val().length > 0 && newData.val().length < 20" },
+        "email": { ".validate": "newData.val().matches(/^[A-Z0-9._%+-]+@[A-Z0-9.-]+\\.[A-Z]{2,}$/i)" },
+        "updated": { ".validate": "newData.val() <= now" },
+        "$other": { ".validate": false }
+      }
+    },
+    "admin": {
+      ".write": "root.child('admins').child(auth.uid).exists()"
+    }
+  }
+}`
+
+func newTestingEvaluator(t *testing.T) *Evaluator {
+	rules, err := ParseRules([]byte(evaluatorRules))
+	assert.NoError(t, err)
+	e, err := NewEvaluator(rules)
+	assert.NoError(t, err)
+	e.Now = func() time.Time { return time.Unix(1000, 0) }
+	err = e.SetData(map[string]interface{}{
+		"admins": map[string]interface{}{"root": true},
+		"users": map[string]interface{}{
+			"ada":  map[string]interface{}{"name": "Ada", "updated": 5},
+			"bob":  map[string]interface{}{"name": "Bob", "locked": true},
+			"none": nil,
+		},
+	})
+	assert.NoError(t, err)
+	return e
+}
+
+func TestEvaluatorRead(t *testing.T) {
+	e := newTestingEvaluator(t)
+	assert.True(t, e.CanRead(nil, "/public/x/y"))
+	assert.False(t, e.CanRead(nil, "/"))
+	assert.False(t, e.CanRead(nil, "/users/ada"))
+	assert.True(t, e.CanRead(UserAuth("ada"), "/users/ada"))
+	assert.True(t, e.CanRead(UserAuth("ada"), "/users/ada/name"))
+	assert.False(t, e.CanRead(UserAuth("ada"), "/users/bob"))
+	assert.False(t, e.CanRead(UserAuth("ada"), "/users"))
+}
+
+func TestEvaluatorWrite(t *testing.T) {
+	e := newTestingEvaluator(t)
+	ok, err := e.CanSet(UserAuth("ada"), "/users/ada", map[string]interface{}{"name": "Ada L.", "email": "ada@example.com"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, _ = e.CanSet(UserAuth("ada"), "/users/ada", map[string]interface{}{"name": "Ada", "email": "not an email"})
+	assert.False(t, ok)
+	ok, _ = e.CanSet(UserAuth("ada"), "/users/ada", map[string]interface{}{"email": "ada@example.com"})
+	assert.False(t, ok, "name is required")
+	ok, _ = e.CanSet(UserAuth("ada"), "/users/ada", map[string]interface{}{"name": "Ada", "age": 36})
+	assert.False(t, ok, "unknown children are rejected")
+	ok, _ = e.CanSet(UserAuth("ada"), "/users/ada/updated", 999999)
+	assert.True(t, ok)
+	ok, _ = e.CanSet(UserAuth("ada"), "/users/ada/updated", 1000001)
+	assert.False(t, ok, "updated is in the future")
+	ok, _ = e.CanSet(UserAuth("ada"), "/users/ada/name", nil)
+	assert.False(t, ok, "the parent would have no name")
+	ok, _ = e.CanSet(UserAuth("ada"), "/users/ada", nil)
+	assert.True(t, ok, "removal is not validated")
+
+	ok, _ = e.CanSet(UserAuth("bob"), "/users/bob/name", "Robert")
+	assert.False(t, ok, "bob is locked")
+	ok, _ = e.CanSet(nil, "/users/ada/name", "Eve")
+	assert.False(t, ok)
+
+	ok, _ = e.CanUpdate(UserAuth("ada"), "/users/ada", map[string]interface{}{"name": "Ada", "updated": 10})
+	assert.True(t, ok)
+	ok, _ = e.CanUpdate(UserAuth("ada"), "/users", map[string]interface{}{"ada/name": "Ada", "bob/name": "Bob"})
+	assert.False(t, ok)
+
+	ok, _ = e.CanSet(UserAuth("root"), "/admin/x", 1)
+	assert.True(t, ok)
+	ok, _ = e.CanSet(UserAuth("ada"), "/admin/x", 1)
+	assert.False(t, ok)
+
+	ok, err = e.Check(UserAuth("ada"), "PATCH", "/users/ada", []byte(`{"name": "Ada"}`))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = e.Check(UserAuth("ada"), "GET", "/users/bob", nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestRuleExpressions(t *testing.T) {
+	e := newTestingEvaluator(t)
+	ctx := e.context(UserAuth("ada"), e.data)
+	for expr, expected := range map[string]interface{}{
+		"1 + 2 * 3":                             7.0,
+		"(1 + 2) * 3":                           9.0,
+		"10 / 4 - 1":                            1.5,
+		"7 % 4":                                 3.0,
+		"5.5 % 2":                               1.5,
+		"7 % 0.5":                               0.0,
+		"-7 % 4":                                -3.0,
+		"-auth.uid.length":                      -3.0,
+		"'a' + 1":                               "a1",
+		"'abc'.toUpperCase().replace('B', 'x')": "AxC",
+		"'abc' < 'abd' && 2 >= 2":               true,
+		"auth.token.sub === 'ada' ? 1 : 2":      1.0,
+		"auth['uid'] != 'bob'":                  true,
+		"root.child('users/ada/name').val()":    "Ada",
+		"root.child('users/ada').parent().hasChildren(['ada', 'bob'])": true,
+		"root.child('users').hasChild('none')":                         false,
+		"root.child('admins/root').isBoolean()":                        true,
+		"'Ada' .matches(/^a/)":                                         false,
+		"'Ada'.matches(/^a/i)":                                         true,
+		"now":                                                          1000000.0,
+	} {
+		compiled, err := compileRule(expr)
+		if !assert.NoError(t, err, expr) {
+			continue
+		}
+		v, err := compiled.eval(ctx)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, expected, v, expr)
+	}
+	for _, expr := range []string{"1 +", "(1", "a b", "'abc", "/abc", "1 ? 2", "#"} {
+		_, err := compileRule(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestRuleModuloByZero(t *testing.T) {
+	e := newTestingEvaluator(t)
+	ctx := e.context(UserAuth("ada"), e.data)
+	for _, expr := range []string{"7 % 0", "7 % -0", "root.child('users/ada/updated').val() % 0 == 0"} {
+		compiled, err := compileRule(expr)
+		assert.NoError(t, err, expr)
+		_, err = compiled.eval(ctx)
+		assert.Error(t, err, expr)
+	}
+
+	rules, err := ParseRules([]byte(`{"rules": {"n": {".write": true, ".validate": "newData.val() % data.val() == 0"}}}`))
+	assert.NoError(t, err)
+	e, err = NewEvaluator(rules)
+	assert.NoError(t, err)
+	assert.NoError(t, e.SetData(map[string]interface{}{"n": 0}))
+	ok, _ := e.CanSet(nil, "/n", 4)
+	assert.False(t, ok)
+	ok, _ = e.Check(nil, "PUT", "/n", []byte(`4`))
+	assert.False(t, ok)
+}
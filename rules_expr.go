@@ -0,0 +1,673 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements the expression language of the security rules.
+// See https://firebase.google.com/docs/reference/security/database
+// for the complete reference.
+
+package firebasedb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ruleToken is a lexical token of a rule expression.
+type ruleToken struct {
+	kind  string // "num", "str", "ident", "regex", "op" or "eof"
+	text  string
+	num   float64
+	flags string // for regular expressions
+}
+
+// lexRule splits a rule expression into tokens.
+func lexRule(src string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	operand := func() bool { // true if the previous token ends an operand
+		if len(tokens) == 0 {
+			return false
+		}
+		t := tokens[len(tokens)-1]
+		return t.kind != "op" || t.text == ")" || t.text == "]"
+	}
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid number %v", src[i:j]))
+			}
+			tokens = append(tokens, ruleToken{kind: "num", text: src[i:j], num: n})
+			i = j
+		case c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' ||
+				src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, ruleToken{kind: "ident", text: src[i:j]})
+			i = j
+		case c == '\'' || c == '"':
+			var b bytes.Buffer
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(src[j])
+					}
+				} else {
+					b.WriteByte(src[j])
+				}
+			}
+			if j >= len(src) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, ruleToken{kind: "str", text: b.String()})
+			i = j + 1
+		case c == '/' && !operand():
+			j := i + 1
+			for ; j < len(src) && src[j] != '/'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, errors.New("unterminated regular expression")
+			}
+			k := j + 1
+			for k < len(src) && src[k] >= 'a' && src[k] <= 'z' {
+				k++
+			}
+			tokens = append(tokens, ruleToken{kind: "regex", text: src[i+1 : j], flags: src[j+1 : k]})
+			i = k
+		default:
+			op := ""
+			for _, candidate := range []string{"===", "!==", "==", "!=", "<=", ">=", "&&", "||"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("()[].,?:!<>+-*/%", rune(c)) {
+					return nil, errors.New(fmt.Sprintf("unexpected character %q", c))
+				}
+				op = string(c)
+			}
+			tokens = append(tokens, ruleToken{kind: "op", text: op})
+			i += len(op)
+		}
+	}
+	return append(tokens, ruleToken{kind: "eof"}), nil
+}
+
+// ruleExpr is a node of the abstract syntax tree of a rule expression.
+type ruleExpr interface {
+	eval(ctx *ruleContext) (interface{}, error)
+}
+
+type (
+	ruleLiteral struct{ value interface{} }
+	ruleIdent   struct{ name string }
+	ruleArray   struct{ items []ruleExpr }
+	ruleMember  struct {
+		object ruleExpr
+		name   ruleExpr
+	}
+	ruleCall struct {
+		fn   ruleExpr
+		args []ruleExpr
+	}
+	ruleUnary struct {
+		op      string
+		operand ruleExpr
+	}
+	ruleBinary struct {
+		op          string
+		left, right ruleExpr
+	}
+	ruleTernary struct{ cond, then, otherwise ruleExpr }
+)
+
+// ruleParser is a recursive descent parser for rule expressions.
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+func (p *ruleParser) peek() ruleToken {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() ruleToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *ruleParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != "op" {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *ruleParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		return errors.New(fmt.Sprintf("expected %q, got %q", op, p.peek().text))
+	}
+	return nil
+}
+
+func (p *ruleParser) ternary() (ruleExpr, error) {
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return &ruleTernary{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// ruleBinaryLevels lists the binary operators from the lowest to the highest precedence.
+var ruleBinaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"===", "!==", "==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *ruleParser) binary(level int) (ruleExpr, error) {
+	if level == len(ruleBinaryLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ruleBinaryLevels[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &ruleBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *ruleParser) unary() (ruleExpr, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &ruleUnary{op: op, operand: operand}, nil
+	}
+	return p.postfix()
+}
+
+func (p *ruleParser) list(closing string) ([]ruleExpr, error) {
+	var items []ruleExpr
+	if _, ok := p.accept(closing); ok {
+		return items, nil
+	}
+	for {
+		item, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if _, ok := p.accept(","); !ok {
+			return items, p.expect(closing)
+		}
+	}
+}
+
+func (p *ruleParser) postfix() (ruleExpr, error) {
+	expr, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("."); ok {
+			t := p.next()
+			if t.kind != "ident" {
+				return nil, errors.New(fmt.Sprintf("expected a property name, got %q", t.text))
+			}
+			expr = &ruleMember{object: expr, name: &ruleLiteral{t.text}}
+		} else if _, ok := p.accept("["); ok {
+			name, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			expr = &ruleMember{object: expr, name: name}
+		} else if _, ok := p.accept("("); ok {
+			args, err := p.list(")")
+			if err != nil {
+				return nil, err
+			}
+			expr = &ruleCall{fn: expr, args: args}
+		} else {
+			return expr, nil
+		}
+	}
+}
+
+func (p *ruleParser) primary() (ruleExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case "num":
+		return &ruleLiteral{t.num}, nil
+	case "str":
+		return &ruleLiteral{t.text}, nil
+	case "regex":
+		expr := t.text
+		if strings.Contains(t.flags, "i") {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid regular expression /%v/: %v", t.text, err))
+		}
+		return &ruleLiteral{re}, nil
+	case "ident":
+		switch t.text {
+		case "true":
+			return &ruleLiteral{true}, nil
+		case "false":
+			return &ruleLiteral{false}, nil
+		case "null":
+			return &ruleLiteral{nil}, nil
+		}
+		return &ruleIdent{t.text}, nil
+	case "op":
+		if t.text == "(" {
+			expr, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		}
+		if t.text == "[" {
+			items, err := p.list("]")
+			if err != nil {
+				return nil, err
+			}
+			return &ruleArray{items}, nil
+		}
+	}
+	if t.kind == "eof" {
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, errors.New(fmt.Sprintf("unexpected %q", t.text))
+}
+
+// compileRule parses a rule expression.
+func compileRule(src string) (ruleExpr, error) {
+	tokens, err := lexRule(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{tokens: tokens}
+	expr, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != "eof" {
+		return nil, errors.New(fmt.Sprintf("unexpected %q", p.peek().text))
+	}
+	return expr, nil
+}
+
+// ruleMethod is a method bound to its receiver, waiting to be called.
+type ruleMethod struct {
+	receiver interface{}
+	name     string
+}
+
+func (e *ruleLiteral) eval(ctx *ruleContext) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e *ruleIdent) eval(ctx *ruleContext) (interface{}, error) {
+	switch e.name {
+	case "auth":
+		return ctx.auth, nil
+	case "now":
+		return ctx.now, nil
+	case "root":
+		return ctx.root, nil
+	case "data":
+		return ctx.data, nil
+	case "newData":
+		return ctx.newData, nil
+	}
+	if v, ok := ctx.vars[e.name]; ok {
+		return v, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown variable %v", e.name))
+}
+
+func (e *ruleArray) eval(ctx *ruleContext) (interface{}, error) {
+	result := make([]interface{}, len(e.items))
+	for i, item := range e.items {
+		v, err := item.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+func (e *ruleMember) eval(ctx *ruleContext) (interface{}, error) {
+	object, err := e.object.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	n, err := e.name.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := n.(string)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("invalid property name %v", n))
+	}
+	switch o := object.(type) {
+	case nil:
+		return nil, errors.New(fmt.Sprintf("can't read property %v of null", name))
+	case map[string]interface{}:
+		return o[name], nil
+	case string:
+		if name == "length" {
+			return float64(len(o)), nil
+		}
+		return &ruleMethod{receiver: o, name: name}, nil
+	case *ruleSnapshot:
+		return &ruleMethod{receiver: o, name: name}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("can't read property %v of %v", name, object))
+}
+
+func (e *ruleCall) eval(ctx *ruleContext) (interface{}, error) {
+	fn, err := e.fn.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	method, ok := fn.(*ruleMethod)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%v is not a function", fn))
+	}
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		if args[i], err = arg.eval(ctx); err != nil {
+			return nil, err
+		}
+	}
+	switch receiver := method.receiver.(type) {
+	case string:
+		return callStringMethod(receiver, method.name, args)
+	case *ruleSnapshot:
+		return receiver.call(method.name, args)
+	}
+	return nil, errors.New(fmt.Sprintf("unknown method %v", method.name))
+}
+
+// stringArgs checks that all arguments are strings.
+func stringArgs(name string, args []interface{}, n int) ([]string, error) {
+	if len(args) != n {
+		return nil, errors.New(fmt.Sprintf("%v expects %v argument(s)", name, n))
+	}
+	result := make([]string, n)
+	for i, a := range args {
+		s, ok := a.(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("%v expects string arguments", name))
+		}
+		result[i] = s
+	}
+	return result, nil
+}
+
+func callStringMethod(s string, name string, args []interface{}) (interface{}, error) {
+	switch name {
+	case "toLowerCase", "toUpperCase":
+		if len(args) != 0 {
+			return nil, errors.New(fmt.Sprintf("%v expects no argument", name))
+		}
+		if name == "toLowerCase" {
+			return strings.ToLower(s), nil
+		}
+		return strings.ToUpper(s), nil
+	case "contains", "beginsWith", "endsWith":
+		a, err := stringArgs(name, args, 1)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "contains":
+			return strings.Contains(s, a[0]), nil
+		case "beginsWith":
+			return strings.HasPrefix(s, a[0]), nil
+		default:
+			return strings.HasSuffix(s, a[0]), nil
+		}
+	case "replace":
+		a, err := stringArgs(name, args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Replace(s, a[0], a[1], -1), nil
+	case "matches":
+		if len(args) != 1 {
+			return nil, errors.New("matches expects 1 argument")
+		}
+		re, ok := args[0].(*regexp.Regexp)
+		if !ok {
+			return nil, errors.New("matches expects a regular expression")
+		}
+		return re.MatchString(s), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown string method %v", name))
+}
+
+// ruleBool converts a value to a boolean. The rules language does not convert
+// other types to booleans.
+func ruleBool(v interface{}) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, errors.New(fmt.Sprintf("%v is not a boolean", v))
+	}
+	return b, nil
+}
+
+func (e *ruleUnary) eval(ctx *ruleContext) (interface{}, error) {
+	v, err := e.operand.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "!" {
+		b, err := ruleBool(v)
+		return !b, err
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%v is not a number", v))
+	}
+	return -n, nil
+}
+
+func (e *ruleBinary) eval(ctx *ruleContext) (interface{}, error) {
+	left, err := e.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "&&" || e.op == "||" {
+		l, err := ruleBool(left)
+		if err != nil {
+			return nil, err
+		}
+		if l == (e.op == "||") { // short circuit
+			return l, nil
+		}
+		right, err := e.right.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ruleBool(right)
+	}
+	right, err := e.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==", "===":
+		return ruleEqual(left, right), nil
+	case "!=", "!==":
+		return !ruleEqual(left, right), nil
+	case "+":
+		ls, lok := left.(string)
+		rs, rok := right.(string)
+		if lok || rok {
+			if !lok {
+				ls = fmt.Sprintf("%v", left)
+			}
+			if !rok {
+				rs = fmt.Sprintf("%v", right)
+			}
+			return ls + rs, nil
+		}
+	case "<", "<=", ">", ">=":
+		ls, lok := left.(string)
+		rs, rok := right.(string)
+		if lok && rok {
+			return compareResult(e.op, strings.Compare(ls, rs)), nil
+		}
+	}
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, errors.New(fmt.Sprintf("invalid operands for %v: %v and %v", e.op, left, right))
+	}
+	switch e.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, errors.New(fmt.Sprintf("modulo by zero: %v %% %v", l, r))
+		}
+		return math.Mod(l, r), nil
+	}
+	c := 0
+	if l < r {
+		c = -1
+	} else if l > r {
+		c = 1
+	}
+	return compareResult(e.op, c), nil
+}
+
+func compareResult(op string, c int) bool {
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// ruleEqual implements the strict equality of the rules language.
+func ruleEqual(a, b interface{}) bool {
+	switch a.(type) {
+	case nil, bool, float64, string:
+		switch b.(type) {
+		case nil, bool, float64, string:
+			return a == b
+		}
+	}
+	return false
+}
+
+func (e *ruleTernary) eval(ctx *ruleContext) (interface{}, error) {
+	v, err := e.cond.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cond, err := ruleBool(v)
+	if err != nil {
+		return nil, err
+	}
+	if cond {
+		return e.then.eval(ctx)
+	}
+	return e.otherwise.eval(ctx)
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testingRules = `{
+  // only authenticated users
+  "rules": {
+    ".read": false, /* block
+    comment */ ".write": false,
+    "users": {
+      "$uid": {
+        ".read": "auth != null && auth.uid == $uid",
+        ".write": "auth.uid == $uid",
+        "url": { ".validate": "newData.val().beginsWith('http://')" }
+      },
+      ".indexOn": "age"
+    }
+  }
+}`
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(testingRules))
+	assert.NoError(t, err)
+	assert.Equal(t, "false", rules.Root.Read)
+	assert.Equal(t, "false", rules.Root.Write)
+	users := rules.Root.Children["users"]
+	assert.NotNil(t, users)
+	assert.Equal(t, []string{"age"}, users.IndexOn)
+	uid := users.Children["$uid"]
+	assert.NotNil(t, uid)
+	assert.Equal(t, "auth != null && auth.uid == $uid", uid.Read)
+	assert.Equal(t, "newData.val().beginsWith('http://')", uid.Children["url"].Validate)
+
+	b, err := json.Marshal(rules)
+	assert.NoError(t, err)
+	again, err := ParseRules(b)
+	assert.NoError(t, err)
+	assert.Empty(t, DiffRules(rules, again))
+}
+
+func TestValidateRules(t *testing.T) {
+	assert.NoError(t, ValidateRules([]byte(`{"rules": {}}`)))
+	assert.Error(t, ValidateRules([]byte(`{"rules": {".read": true,}}`)))
+	assert.Error(t, ValidateRules([]byte(`{"rules": {".read": 1}}`)))
+	assert.Error(t, ValidateRules([]byte(`{"rules": {".reed": true}}`)))
+	assert.Error(t, ValidateRules([]byte(`{"rules": {".read": "auth.uid =="}}`)))
+	assert.Error(t, ValidateRules([]byte(`{"rules": {"$a": {}, "$b": {}}}`)))
+	assert.Error(t, ValidateRules([]byte(`{"rules": {"a": true}}`)))
+	assert.Error(t, ValidateRules([]byte(`{"rulez": {}}`)))
+	assert.Error(t, ValidateRules([]byte(`{"rules": {} /* oops }`)))
+}
+
+func TestDiffRules(t *testing.T) {
+	local, err := ParseRules([]byte(`{"rules": {".read": true, "a": {".indexOn": ["y", "x"]}}}`))
+	assert.NoError(t, err)
+	deployed, err := ParseRules([]byte(`{"rules": {".read": "true", ".write": false, "a": {".indexOn": ["x"]}}}`))
+	assert.NoError(t, err)
+	diff := DiffRules(local, deployed)
+	assert.Equal(t, []RuleDiff{
+		{Path: "/", Rule: ".write", Local: "", Deployed: "false"},
+		{Path: "/a", Rule: ".indexOn", Local: "x,y", Deployed: "x"},
+	}, diff)
+}
@@ -0,0 +1,216 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.google.com/docs/database/usage/sharding
+
+package firebasedb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	urllib "net/url"
+	pathlib "path"
+)
+
+// Shard is one database instance of a ShardedClient.
+type Shard struct {
+	URL  string
+	Auth Authenticator
+}
+
+// ShardRouter returns the index of the shard (between 0 and shards-1) holding
+// the path. The path is absolute and clean, for example "/users/ada".
+type ShardRouter func(path string, shards int) (int, error)
+
+// PrefixRouter routes the paths with the longest matching prefix of prefixes.
+// The prefixes are compared segment by segment, so "/users" matches "/users/ada"
+// but not "/usersettings". The paths without a matching prefix go to the fallback
+// shard; they are rejected if fallback is negative.
+func PrefixRouter(prefixes map[string]int, fallback int) ShardRouter {
+	return func(path string, shards int) (int, error) {
+		segments := splitPath(path)
+		best, shard := -1, fallback
+		for prefix, i := range prefixes {
+			p := splitPath(prefix)
+			if len(p) <= best || len(p) > len(segments) {
+				continue
+			}
+			match := true
+			for k := range p {
+				if p[k] != segments[k] {
+					match = false
+					break
+				}
+			}
+			if match {
+				best, shard = len(p), i
+			}
+		}
+		if shard < 0 {
+			return 0, errors.New(fmt.Sprintf("No shard for the path %v", path))
+		}
+		return shard, nil
+	}
+}
+
+// HashRouter routes the paths using a hash of the key at the given level of the
+// path (0 is the first segment). For example, HashRouter(1) distributes the children
+// of "/users" and everything below them: "/users/ada/name" goes to the shard of "ada".
+// The paths with less than level+1 segments span all shards and are rejected.
+func HashRouter(level int) ShardRouter {
+	return func(path string, shards int) (int, error) {
+		segments := splitPath(path)
+		if level >= len(segments) {
+			return 0, errors.New(fmt.Sprintf("The path %v spans several shards", path))
+		}
+		h := fnv.New32a()
+		h.Write([]byte(segments[level]))
+		return int(h.Sum32() % uint32(shards)), nil
+	}
+}
+
+// ShardedClient distributes the data over several database instances. The
+// references returned by Ref are routed when a request is sent, so Child, Parent
+// and the queries can move a reference to a location held by another shard.
+//
+// The locations that don't belong to one shard (for example the root of the data
+// distributed with a HashRouter) can't be read or written through the client;
+// use the Database of each shard instead.
+type ShardedClient struct {
+	shards []*Database
+	router ShardRouter
+	root   *Reference
+	cancel context.CancelFunc
+}
+
+// NewShardedClient creates a client for the shards. Each shard is a Database
+// created from config, with the URL and the authenticator of the shard.
+func NewShardedClient(config Config, router ShardRouter, shards ...Shard) (*ShardedClient, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("A sharded client needs at least one shard")
+	}
+	if router == nil {
+		return nil, errors.New("A sharded client needs a router")
+	}
+	c := &ShardedClient{router: router}
+	for _, shard := range shards {
+		cfg := config
+		cfg.URL, cfg.Auth = shard.URL, shard.Auth
+		db, err := NewDatabase(cfg)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.shards = append(c.shards, db)
+	}
+	root := *c.shards[0].root
+	root.ctx, c.cancel = context.WithCancel(context.Background())
+	root.auth = nil
+	root.db = nil
+	root.sharded = c
+	c.root = &root
+	return c, nil
+}
+
+// Ref returns a reference to the specified path. The reference is routed to its
+// shard when it is used.
+func (c *ShardedClient) Ref(path string) *Reference {
+	return c.root.Ref(path)
+}
+
+// RefFromUrl returns a reference to the path specified in url. The error of the
+// reference is set if the host of the url is not the host of one of the shards.
+func (c *ShardedClient) RefFromUrl(url urllib.URL) *Reference {
+	return c.root.RefFromUrl(url)
+}
+
+// Shard returns the database holding the path.
+func (c *ShardedClient) Shard(path string) (*Database, error) {
+	i, err := c.shardIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.shards[i], nil
+}
+
+// Shards returns the databases of the client, in the order of the shards passed
+// to NewShardedClient.
+func (c *ShardedClient) Shards() []*Database {
+	return append([]*Database{}, c.shards...)
+}
+
+// Close closes all shards.
+func (c *ShardedClient) Close() error {
+	var err error
+	for _, db := range c.shards {
+		if e := db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+	return err
+}
+
+// shardIndex returns the index of the shard holding the path.
+func (c *ShardedClient) shardIndex(path string) (int, error) {
+	path = pathlib.Clean(pathlib.Join("/", path))
+	i, err := c.router(path, len(c.shards))
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 || i >= len(c.shards) {
+		return 0, errors.New(fmt.Sprintf("Invalid shard %v for the path %v", i, path))
+	}
+	return i, nil
+}
+
+// hasHost returns true if host is the host of one of the shards.
+func (c *ShardedClient) hasHost(host string) bool {
+	for _, db := range c.shards {
+		if db.root.url.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the reference routed to its shard. References that are not
+// created by a ShardedClient are returned as is.
+func (r *Reference) resolve() (*Reference, error) {
+	if r.sharded == nil {
+		return r, nil
+	}
+	i, err := r.sharded.shardIndex(r.url.Path)
+	if err != nil {
+		return nil, err
+	}
+	shard := r.sharded.shards[i].root
+	result := *r
+	result.url.Scheme, result.url.Host = shard.url.Scheme, shard.url.Host
+	if ns := shard.namespace(); ns != "" { // the shards of an emulator share the host
+		q := result.url.Query()
+		q.Set("ns", ns)
+		result.url.RawQuery = q.Encode()
+	}
+	result.client = shard.client
+	result.auth = shard.auth
+	result.db = shard.db
+	result.sharded = nil
+	return &result, nil
+}
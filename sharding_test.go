@@ -0,0 +1,159 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixRouter(t *testing.T) {
+	route := PrefixRouter(map[string]int{"users": 1, "/users/admins": 2, "logs/": 3}, 0)
+	for path, shard := range map[string]int{
+		"/":                   0,
+		"/users":              1,
+		"/users/ada":          1,
+		"/users/admins/root":  2,
+		"/usersettings":       0,
+		"/logs/2016/08/24":    3,
+		"/settings/log_level": 0,
+	} {
+		i, err := route(path, 4)
+		assert.NoError(t, err)
+		assert.Equal(t, shard, i, path)
+	}
+	_, err := PrefixRouter(map[string]int{"users": 1}, -1)("/logs", 2)
+	assert.Error(t, err)
+}
+
+func TestHashRouter(t *testing.T) {
+	route := HashRouter(1)
+	ada, err := route("/users/ada", 4)
+	assert.NoError(t, err)
+	name, err := route("/users/ada/name", 4)
+	assert.NoError(t, err)
+	assert.Equal(t, ada, name)
+	_, err = route("/users", 4)
+	assert.Error(t, err)
+
+	seen := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		shard, _ := route(fmt.Sprintf("/users/user%d", i), 4)
+		assert.True(t, shard >= 0 && shard < 4)
+		seen[shard] = true
+	}
+	assert.Equal(t, 4, len(seen))
+}
+
+type shardServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *shardServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req.Method+" "+req.URL.Path+" "+req.URL.Query().Get("auth"))
+	s.mu.Unlock()
+	if req.Header.Get("Accept") == "text/event-stream" {
+		fmt.Fprint(w, "event: put\ndata: {\"path\":\"/\",\"data\":null}\n\n")
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+		return
+	}
+	ioutil.ReadAll(req.Body)
+	fmt.Fprint(w, `"ok"`)
+}
+
+func TestShardedClient(t *testing.T) {
+	var users, logs shardServer
+	usersServer := httptest.NewServer(&users)
+	defer usersServer.Close()
+	logsServer := httptest.NewServer(&logs)
+	defer logsServer.Close()
+
+	_, err := NewShardedClient(Config{}, PrefixRouter(nil, 0))
+	assert.Error(t, err)
+	c, err := NewShardedClient(Config{}, PrefixRouter(map[string]int{"logs": 1}, 0),
+		Shard{URL: usersServer.URL, Auth: Secret{Token: "users-secret"}},
+		Shard{URL: logsServer.URL, Auth: Secret{Token: "logs-secret"}})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	ref := c.Ref("users/ada")
+	assert.Equal(t, usersServer.URL+"/users/ada", ref.String())
+	assert.Equal(t, logsServer.URL+"/logs/today", ref.Root().Child("logs/today").String())
+
+	var v string
+	assert.NoError(t, ref.Value(&v))
+	assert.NoError(t, ref.Root().Child("logs/today").Set("hello"))
+	assert.NoError(t, c.RefFromUrl(url.URL{Host: ref.Root().Child("logs").url.Host, Path: "/logs/yesterday"}).Remove())
+	assert.Error(t, c.RefFromUrl(url.URL{Host: "example.com", Path: "/logs"}).Error)
+	assert.Equal(t, []string{"GET /users/ada.json users-secret"}, users.requests)
+	assert.Equal(t, []string{"PUT /logs/today.json logs-secret", "DELETE /logs/yesterday.json logs-secret"}, logs.requests)
+
+	db, err := c.Shard("logs/today")
+	assert.NoError(t, err)
+	assert.Equal(t, c.Shards()[1], db)
+	sub, err := c.Ref("logs").Subscribe()
+	assert.NoError(t, err)
+	<-sub.Events()
+	assert.Equal(t, 0, c.Shards()[0].Subscriptions())
+	assert.Equal(t, 1, c.Shards()[1].Subscriptions())
+
+	assert.NoError(t, c.Close())
+	for range sub.Events() {
+	}
+	assert.Equal(t, 0, c.Shards()[1].Subscriptions())
+	assert.Equal(t, errDatabaseClosed, ref.Value(&v))
+}
+
+func TestShardedClientHash(t *testing.T) {
+	servers := make([]*shardServer, 3)
+	var shards []Shard
+	for i := range servers {
+		servers[i] = &shardServer{}
+		server := httptest.NewServer(servers[i])
+		defer server.Close()
+		shards = append(shards, Shard{URL: server.URL})
+	}
+	c, err := NewShardedClient(Config{}, HashRouter(1), shards...)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	var v string
+	assert.Error(t, c.Ref("users").Value(&v))
+	_, err = c.Ref("users").Subscribe()
+	assert.Error(t, err)
+	for i := 0; i < 30; i++ {
+		assert.NoError(t, c.Ref("users").Child(fmt.Sprintf("user%d", i)).Set("x"))
+	}
+	total := 0
+	for i, s := range servers {
+		for _, req := range s.requests {
+			shard, err := HashRouter(1)(req[len("PUT "):len(req)-len(".json ")], 3)
+			assert.NoError(t, err)
+			assert.Equal(t, i, shard)
+		}
+		total += len(s.requests)
+	}
+	assert.Equal(t, 30, total)
+}
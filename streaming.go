@@ -20,11 +20,12 @@ package firebasedb
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
-	"net/http"
+	"log/slog"
+	pathlib "path"
 	"strings"
 	"time"
 )
@@ -38,23 +39,32 @@ type Event struct {
 	Type string // can be put, patch, keep-alive, cancel, or auth_revoked
 	Err  error
 	data string
+	ref  *Reference // reference of the subscription
 }
 
 // Value unmarshals data from an event. It returns the data in v and the path
 // of the event in the path return attribute.
 func (e *Event) Value(v interface{}) (path string, err error) {
 	var p struct {
-		Path string      `json:"path"`
-		Data interface{} `json:"data"`
+		Path string          `json:"path"`
+		Data json.RawMessage `json:"data"`
 	}
-	p.Data = &v
-	err = json.Unmarshal([]byte(e.data), &p)
-	if err != nil {
-		path = ""
-	} else {
-		path = p.Path
+	if err = json.Unmarshal([]byte(e.data), &p); err != nil {
+		return "", err
+	}
+	codec, key := defaultCodec, pathlib.Base(p.Path)
+	if e.ref != nil {
+		codec = e.ref.getCodec()
+		if key == "/" {
+			key = e.ref.Key()
+		}
+	}
+	if len(p.Data) > 0 {
+		if err = unmarshalValue(codec, p.Data, v, key); err != nil {
+			return "", err
+		}
 	}
-	return path, err
+	return p.Path, nil
 }
 
 // Subscription is the interface for event subscriptions. Subscriptions
@@ -64,39 +74,55 @@ type Subscription struct {
 	reference     *Reference    // copy of the reference
 	events        chan *Event   // sends events to the user
 	closing       chan bool     // for Close
+	ctx           context.Context
+	span          Span // lifetime of the subscription
 	LastKeepAlive time.Time
 }
 
-func (r *Reference) openStream() (io.ReadCloser, error) {
-	req, err := http.NewRequest("GET", r.addAuth().jsonUrl(), nil)
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("error while building the request: %v", err))
-	}
-	req.Header.Add("Accept", "text/event-stream")
-	response, err := r.do(req)
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("error while executing the request: %v", err))
+// openStream opens the stream of events. It returns the executed operation.
+func (r *Reference) openStream(ctx context.Context) (*Operation, error) {
+	op := r.newOperation(OpSubscribe, "GET", nil, nil)
+	op.Header.Set("Accept", "text/event-stream")
+	if err := r.invoke(ctx, op); err != nil {
+		return op, err
 	}
-	if response.StatusCode != 200 {
-		response.Body.Close()
-		return nil, errors.New(fmt.Sprintf("error, response is : %v", response.Status))
+	if op.Body == nil {
+		return op, errors.New("The stream was not opened")
 	}
-	return response.Body, nil
+	return op, nil
 }
 
 // Subscribe returns a subscription on the reference. The returned subscription
 // is used to access the streamed events.
 func (r *Reference) Subscribe() (*Subscription, error) {
-	reader, err := r.openStream()
+	r.recordQuery()
+	r, err := r.resolve()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := r.startSpan(OpSubscribe)
+	op, err := r.openStream(ctx)
 	if err != nil {
+		r.endSpan(span, op, err)
 		return nil, err
 	}
+	span.SetAttribute(AttrStatusCode, op.Status)
 	s := &Subscription{
-		reader:    reader,
+		reader:    op.Body,
 		reference: r,
 		events:    make(chan *Event), // for Events
 		closing:   make(chan bool),   // for Close
+		ctx:       ctx,
+		span:      span,
 	}
+	if r.db != nil {
+		if err = r.db.track(s); err != nil {
+			op.Body.Close()
+			r.endSpan(span, op, err)
+			return nil, err
+		}
+	}
+	r.logStream("firebasedb stream opened")
 	go s.loop()
 	return s, nil
 }
@@ -121,6 +147,7 @@ func (s *Subscription) loop() {
 	go func() { // read the payload and feed the fetchEvent channel
 		payload := make([]string, 2)
 		lineCount := 0
+		eventCount := 0
 		r := bufio.NewReader(s.reader)
 		for {
 			line, err := r.ReadString('\n')
@@ -142,6 +169,11 @@ func (s *Subscription) loop() {
 					} else {
 						eventType := strings.Trim(strings.TrimPrefix(payload[0], "event:"), " \r\n")
 						eventData := strings.Trim(strings.TrimPrefix(payload[1], "data:"), " \r\n")
+						eventCount++
+						s.reference.logStream("firebasedb stream event", slog.String("type", eventType), slog.Int("size", len(eventData)))
+						if s.reference.metrics != nil {
+							s.reference.metrics.ObserveEvent(eventType)
+						}
 						switch eventType {
 						case "keep-alive":
 							s.LastKeepAlive = time.Now()
@@ -153,7 +185,11 @@ func (s *Subscription) loop() {
 							if s.reference.auth != nil {
 								if err = s.reference.auth.Renew(); err == nil {
 									s.reader.Close()
-									s.reader, err = s.reference.openStream()
+									var op *Operation
+									if op, err = s.reference.openStream(s.ctx); err == nil {
+										s.reader = op.Body
+									}
+									s.reference.logStream("firebasedb stream reconnected", slog.Any("error", err))
 									if err == nil {
 										r = bufio.NewReader(s.reader)
 										break // everything is OK, no need to send the event further.
@@ -177,25 +213,36 @@ func (s *Subscription) loop() {
 				}
 			}
 		}
+		s.reference.logStream("firebasedb stream closed")
+		if s.reference.db != nil {
+			s.reference.db.untrack(s)
+		}
+		s.span.SetAttribute(AttrEvents, eventCount)
+		s.span.End()
 		s.closing <- true
 	}()
 
+	closing := s.closing
 	for {
 		var first *Event
 		var events chan *Event
 		if len(pending) > 0 {
 			first = &pending[0]
 			events = s.events // enable send case
+		} else if closing == nil {
+			// the stream is closed and all pending events were delivered
+			close(s.events)
+			return
 		}
 
 		select {
 		case event := <-fetchEvent:
 			// Currently, I am not controlling the size of the pending queue.
 			// But the structure of this program enables those check if required.
+			event.ref = s.reference
 			pending = append(pending, event)
-		case <-s.closing:
-			close(s.events)
-			break
+		case <-closing:
+			closing = nil
 		case events <- first:
 			pending = pending[1:]
 		}
@@ -15,9 +15,13 @@
 package firebasedb
 
 import (
-	"github.com/stretchr/testify/assert"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestStream(t *testing.T) {
@@ -77,3 +81,39 @@ func TestStream(t *testing.T) {
 	err = root.Value(&generic)
 
 }
+
+func TestCloseWithPendingEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "event: put\ndata: {\"path\":\"/\",\"data\":%v}\n\n", i)
+		}
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	s, err := NewReference(server.URL).Subscribe()
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond) // the events are received but not read
+	assert.NoError(t, s.Close())
+
+	var values []int
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case e, ok := <-s.Events():
+			if !ok {
+				done = true
+				break
+			}
+			var v int
+			_, err := e.Value(&v)
+			assert.NoError(t, err)
+			values = append(values, v)
+		case <-timeout:
+			t.Fatal("the events channel was not closed")
+		}
+	}
+	assert.Equal(t, []int{0, 1, 2}, values)
+}
@@ -0,0 +1,407 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// References:
+// https://firebase.google.com/docs/reference/rest/database/#section-server-values
+
+package firebasedb
+
+// The fields of a struct can be tagged with the "firebase" key to control how
+// they are written and read:
+//
+//	type Post struct {
+//		ID      string    `firebase:",key"`
+//		Title   string    `firebase:"title"`
+//		Likes   int       `firebase:"likes,omitempty"`
+//		Created time.Time `firebase:"created,servertimestamp"`
+//	}
+//
+// The name is the name of the child in the database. When it is empty, the name
+// given by the "json" tag (or the name of the field) is used. The options are:
+//
+//	omitempty        the field is not written if it has a zero value.
+//	servertimestamp  a zero value is replaced by the server timestamp when the
+//	                 value is written. A time.Time field is stored as the number
+//	                 of milliseconds since the epoch.
+//	key              the field is not written and it is set to the key of the node
+//	                 when the value is read.
+//
+// When a struct using these tags is passed to Update, all zero fields are skipped
+// (including the servertimestamp fields) so that only the fields that are set are
+// written.
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerTimestamp is the placeholder replaced by the time of the server when it is written.
+var ServerTimestamp = map[string]string{".sv": "timestamp"}
+
+var (
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	codecDecoderType    = reflect.TypeOf((*codecDecoder)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// tagField describes a field of a struct using firebase tags.
+type tagField struct {
+	index           []int
+	name            string
+	omitEmpty       bool
+	serverTimestamp bool
+	key             bool
+}
+
+// codecDecoder is implemented by the types that decode their children with the
+// codec of the reference (see List, IntMap and StringMap).
+type codecDecoder interface {
+	decodeWithCodec(codec Codec, data []byte) error
+}
+
+var tagCache = struct {
+	sync.Mutex
+	uses    map[reflect.Type]bool
+	decodes map[reflect.Type]bool
+	fields  map[reflect.Type][]tagField
+}{uses: map[reflect.Type]bool{}, decodes: map[reflect.Type]bool{}, fields: map[reflect.Type][]tagField{}}
+
+// usesTags returns true if the values of type t contain structs with firebase tags.
+func usesTags(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	tagCache.Lock()
+	defer tagCache.Unlock()
+	uses, _ := scanType(t, tagCache.uses, false, map[reflect.Type]bool{})
+	tagCache.uses[t] = uses
+	return uses
+}
+
+// decodesByField returns true if the values of type t must be decoded field by
+// field: they contain structs with firebase tags or codecDecoder values.
+func decodesByField(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	tagCache.Lock()
+	defer tagCache.Unlock()
+	uses, _ := scanType(t, tagCache.decodes, true, map[reflect.Type]bool{})
+	tagCache.decodes[t] = uses
+	return uses
+}
+
+// scanType returns true if the values of type t use tags (or codecDecoder values).
+// complete is false if the result depends on a type that is still being scanned
+// (a recursive type): such a result is only cached if it is true, and the caller
+// at the top of the recursion caches its own result.
+func scanType(t reflect.Type, cache map[reflect.Type]bool, decoders bool, visiting map[reflect.Type]bool) (uses, complete bool) {
+	if uses, ok := cache[t]; ok {
+		return uses, true
+	}
+	if visiting[t] {
+		return false, false
+	}
+	visiting[t] = true
+	uses, complete = decoders && reflect.PtrTo(t).Implements(codecDecoderType), true
+	scan := func(t reflect.Type) {
+		u, c := scanType(t, cache, decoders, visiting)
+		uses, complete = uses || u, complete && c
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		scan(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if _, ok := f.Tag.Lookup("firebase"); ok {
+				uses = true
+			}
+			scan(f.Type)
+		}
+	}
+	if uses || complete {
+		cache[t] = uses
+	}
+	return uses, complete
+}
+
+// tagFields returns the fields of a struct type, with the embedded structs flattened.
+func tagFields(t reflect.Type) []tagField {
+	tagCache.Lock()
+	defer tagCache.Unlock()
+	if fields, ok := tagCache.fields[t]; ok {
+		return fields
+	}
+	fields := appendTagFields(nil, t, nil)
+	tagCache.fields[t] = fields
+	return fields
+}
+
+func appendTagFields(fields []tagField, t reflect.Type, index []int) []tagField {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fbTag, hasFbTag := f.Tag.Lookup("firebase")
+		jsonTag, hasJsonTag := f.Tag.Lookup("json")
+		fieldIndex := append(append([]int(nil), index...), i)
+		if f.Anonymous && !hasFbTag && !hasJsonTag && f.Type.Kind() == reflect.Struct {
+			fields = appendTagFields(fields, f.Type, fieldIndex)
+			continue
+		}
+		if f.PkgPath != "" || fbTag == "-" || (!hasFbTag && jsonTag == "-") {
+			continue
+		}
+		field := tagField{index: fieldIndex, name: f.Name}
+		jsonParts := strings.Split(jsonTag, ",")
+		if jsonParts[0] != "" {
+			field.name = jsonParts[0]
+		}
+		for _, opt := range jsonParts[1:] {
+			field.omitEmpty = field.omitEmpty || opt == "omitempty"
+		}
+		fbParts := strings.Split(fbTag, ",")
+		if fbParts[0] != "" {
+			field.name = fbParts[0]
+		}
+		for _, opt := range fbParts[1:] {
+			switch opt {
+			case "omitempty":
+				field.omitEmpty = true
+			case "servertimestamp":
+				field.serverTimestamp = true
+			case "key":
+				field.key = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// isZero returns true if v is the zero value of its type.
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// encodeTags converts the structs with firebase tags contained in value into maps that
+// can be encoded by encoding/json. If update is true, the zero fields of the top-level
+// struct are skipped.
+func encodeTags(value interface{}, update bool) interface{} {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || !usesTags(v.Type()) {
+		return value
+	}
+	return encodeTagsValue(v, update)
+}
+
+func encodeTagsValue(v reflect.Value, update bool) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	t := v.Type()
+	if !usesTags(t) || t.Implements(jsonMarshalerType) {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return encodeTagsValue(v.Elem(), update)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		result := make([]interface{}, v.Len())
+		for i := range result {
+			result[i] = encodeTagsValue(v.Index(i), false)
+		}
+		return result
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		result := map[string]interface{}{}
+		for _, k := range v.MapKeys() {
+			result[mapKeyString(k)] = encodeTagsValue(v.MapIndex(k), false)
+		}
+		return result
+	case reflect.Struct:
+		result := map[string]interface{}{}
+		for _, field := range tagFields(t) {
+			fv := v.FieldByIndex(field.index)
+			zero := isZero(fv)
+			switch {
+			case field.key:
+			case zero && update:
+			case field.serverTimestamp && zero:
+				result[field.name] = ServerTimestamp
+			case zero && field.omitEmpty:
+			case field.serverTimestamp && fv.Type() == timeType:
+				result[field.name] = fv.Interface().(time.Time).UnixNano() / int64(time.Millisecond)
+			default:
+				result[field.name] = encodeTagsValue(fv, false)
+			}
+		}
+		return result
+	}
+	return v.Interface()
+}
+
+// mapKeyString converts the key of a map to a string.
+func mapKeyString(k reflect.Value) string {
+	switch k.Kind() {
+	case reflect.String:
+		return k.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+	b, _ := json.Marshal(k.Interface())
+	return strings.Trim(string(b), `"`)
+}
+
+// decodeValue decodes the JSON document read from reader into value. The fields
+// tagged with "key" are set to the key of their node, key being the key of the
+// document itself.
+func decodeValue(codec Codec, reader io.Reader, value interface{}, key string) error {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || !decodesByField(v.Type()) {
+		return codec.NewDecoder(reader).Decode(value)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(reader).Decode(&raw); err != nil {
+		return err
+	}
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return &json.InvalidUnmarshalError{Type: v.Type()}
+	}
+	return decodeTagsValue(codec, raw, v.Elem(), key)
+}
+
+// unmarshalValue is the equivalent of decodeValue for a byte slice.
+func unmarshalValue(codec Codec, data []byte, value interface{}, key string) error {
+	return decodeValue(codec, bytes.NewReader(data), value, key)
+}
+
+func decodeTagsValue(codec Codec, raw json.RawMessage, v reflect.Value, key string) error {
+	if d, ok := v.Addr().Interface().(codecDecoder); ok {
+		return d.decodeWithCodec(codec, raw)
+	}
+	t := v.Type()
+	if !decodesByField(t) || reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return codec.Unmarshal(raw, v.Addr().Interface())
+	}
+	null := string(bytes.TrimSpace(raw)) == "null"
+	switch v.Kind() {
+	case reflect.Ptr:
+		if null {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return decodeTagsValue(codec, raw, v.Elem(), key)
+	case reflect.Slice:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+		if items == nil {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		result := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err := decodeTagsValue(codec, item, result.Index(i), strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(result)
+		return nil
+	case reflect.Map:
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+		if items == nil {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+		for k, item := range items {
+			kv := reflect.New(t.Key()).Elem()
+			if err := json.Unmarshal([]byte(strconv.Quote(k)), kv.Addr().Interface()); err != nil {
+				if err = json.Unmarshal([]byte(k), kv.Addr().Interface()); err != nil {
+					return err
+				}
+			}
+			ev := reflect.New(t.Elem()).Elem()
+			if err := decodeTagsValue(codec, item, ev, k); err != nil {
+				return err
+			}
+			v.SetMapIndex(kv, ev)
+		}
+		return nil
+	case reflect.Struct:
+		if null {
+			return nil
+		}
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+		for _, field := range tagFields(t) {
+			fv := v.FieldByIndex(field.index)
+			if field.key {
+				if fv.Kind() == reflect.String {
+					fv.SetString(key)
+				}
+				continue
+			}
+			item, ok := items[field.name]
+			if !ok {
+				continue
+			}
+			if field.serverTimestamp && fv.Type() == timeType {
+				var ms int64
+				if err := json.Unmarshal(item, &ms); err != nil {
+					return err
+				}
+				fv.Set(reflect.ValueOf(time.Unix(0, ms*int64(time.Millisecond))))
+				continue
+			}
+			if err := decodeTagsValue(codec, item, fv, field.name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return codec.Unmarshal(raw, v.Addr().Interface())
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tagAudit struct {
+	Created time.Time `firebase:"created,servertimestamp"`
+}
+
+type tagPost struct {
+	tagAudit
+	ID     string   `firebase:",key"`
+	Title  string   `firebase:"title"`
+	Likes  int      `firebase:"likes,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Hidden string   `firebase:"-"`
+}
+
+func encodeString(t *testing.T, value interface{}, update bool) string {
+	b, err := json.Marshal(encodeTags(value, update))
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestEncodeTags(t *testing.T) {
+	post := tagPost{ID: "p1", Title: "Hello", Hidden: "x"}
+	assert.Equal(t, `{"created":{".sv":"timestamp"},"title":"Hello"}`, encodeString(t, post, false))
+	assert.Equal(t, `{"title":"Hello"}`, encodeString(t, &post, true))
+
+	post.Created = time.Unix(1500000000, 0)
+	post.Likes = 3
+	assert.Equal(t, `{"created":1500000000000,"likes":3,"title":"Hello"}`, encodeString(t, post, false))
+	assert.Equal(t, `{"created":1500000000000,"likes":3}`, encodeString(t, tagPost{tagAudit: post.tagAudit, Likes: 3}, true))
+
+	assert.Equal(t, `{"a":{"created":{".sv":"timestamp"},"title":""}}`, encodeString(t, map[string]tagPost{"a": {}}, false))
+
+	// values without firebase tags are not changed
+	assert.Equal(t, `{"A":0}`, encodeString(t, struct{ A int }{}, true))
+}
+
+type tagNode struct {
+	Name     string    `firebase:"name"`
+	Secret   string    `firebase:"-"`
+	Children []tagNode `json:"children,omitempty"`
+}
+
+func TestRecursiveTags(t *testing.T) {
+	node := tagNode{Name: "a", Secret: "s", Children: []tagNode{{Name: "b", Secret: "t"}}}
+	assert.Equal(t, `{"children":[{"name":"b"}],"name":"a"}`, encodeString(t, node, false))
+	assert.Equal(t, `[{"name":"b"}]`, encodeString(t, node.Children, false))
+
+	var decoded tagNode
+	err := unmarshalValue(defaultCodec, []byte(`{"name":"a","children":[{"name":"b","Secret":"t"}]}`), &decoded, "")
+	assert.NoError(t, err)
+	assert.Equal(t, tagNode{Name: "a", Children: []tagNode{{Name: "b"}}}, decoded)
+}
+
+func TestDecodeTags(t *testing.T) {
+	var post tagPost
+	err := unmarshalValue(defaultCodec, []byte(`{"created":1500000000000,"title":"Hello","likes":3,"tags":["a"]}`), &post, "p1")
+	assert.NoError(t, err)
+	assert.Equal(t, "p1", post.ID)
+	assert.Equal(t, "Hello", post.Title)
+	assert.Equal(t, 3, post.Likes)
+	assert.Equal(t, []string{"a"}, post.Tags)
+	assert.True(t, post.Created.Equal(time.Unix(1500000000, 0)))
+
+	var posts map[string]*tagPost
+	err = unmarshalValue(defaultCodec, []byte(`{"p1":{"title":"A"},"p2":{"title":"B"}}`), &posts, "posts")
+	assert.NoError(t, err)
+	assert.Equal(t, "p1", posts["p1"].ID)
+	assert.Equal(t, "B", posts["p2"].Title)
+	assert.Equal(t, "p2", posts["p2"].ID)
+}
+
+func TestUpdateWithTags(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		body = req.Method + " " + string(b)
+		w.Write([]byte(`{"title":"Hello","likes":4}`))
+	}))
+	defer server.Close()
+	ref := NewReference(server.URL).Ref("posts/p1")
+
+	assert.NoError(t, ref.Update(tagPost{Likes: 4, tagAudit: tagAudit{Created: time.Unix(1, 0)}}))
+	assert.Equal(t, "PATCH {\"created\":1000,\"likes\":4}", body)
+	assert.NoError(t, ref.Update(tagPost{Likes: 5}))
+	assert.Equal(t, "PATCH {\"likes\":5}", body)
+
+	var post tagPost
+	assert.NoError(t, ref.Value(&post))
+	assert.Equal(t, tagPost{ID: "p1", Title: "Hello", Likes: 4}, post)
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"context"
+)
+
+// Span is a traced operation. It follows the conventions of OpenTelemetry, so
+// that an OpenTelemetry span can be wrapped with a few lines of code.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts the spans of the operations. The returned context carries the
+// span and is used for the requests of the operation, so that it can be
+// propagated by an instrumented HTTP transport.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// The attributes set on the spans.
+const (
+	AttrOperation  = "firebasedb.operation"
+	AttrPath       = "firebasedb.path"
+	AttrQuery      = "firebasedb.query"
+	AttrStatusCode = "http.status_code"
+	AttrRetries    = "firebasedb.retries"
+	AttrEvents     = "firebasedb.events"
+)
+
+// WithContext returns a reference whose requests and streams use ctx. The context
+// is used to cancel the requests and as the parent of the spans.
+func (r *Reference) WithContext(ctx context.Context) *Reference {
+	result := *r
+	result.ctx = ctx
+	return &result
+}
+
+// Context returns the context of the reference (context.Background() if none was set).
+func (r *Reference) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithTracer returns a reference that creates a span for each Value, Set, Update,
+// Push and Remove, and for the lifetime of each subscription.
+func (r *Reference) WithTracer(tracer Tracer) *Reference {
+	result := *r
+	result.tracer = tracer
+	return &result
+}
+
+// noopSpan is used when the reference has no tracer.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (noopSpan) End()                                       {}
+
+// startSpan starts the span of an operation on the reference.
+func (r *Reference) startSpan(op string) (context.Context, Span) {
+	if r.tracer == nil {
+		return r.Context(), noopSpan{}
+	}
+	ctx, span := r.tracer.Start(r.Context(), "firebasedb."+op)
+	span.SetAttribute(AttrOperation, op)
+	span.SetAttribute(AttrPath, r.logPath(&r.url))
+	span.SetAttribute(AttrQuery, redactedURL(&r.url).RawQuery)
+	return ctx, span
+}
+
+// endSpan records the result of an operation and ends the span. The status is 0
+// if no response was received.
+func (r *Reference) endSpan(span Span, op *Operation, err error) {
+	if op.Status != 0 {
+		span.SetAttribute(AttrStatusCode, op.Status)
+	}
+	if op.Attempts > 1 {
+		span.SetAttribute(AttrRetries, op.Attempts-1)
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
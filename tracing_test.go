@@ -0,0 +1,123 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type spanKey struct{}
+
+type testSpan struct {
+	name       string
+	parent     interface{}
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *testSpan) RecordError(err error)                      { s.err = err }
+func (s *testSpan) End()                                       { s.ended = true }
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &testSpan{name: name, parent: ctx.Value(spanKey{}), attributes: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, name), span
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestTracer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Header.Get("Accept") == "text/event-stream":
+			fmt.Fprint(w, "event: put\ndata: {\"path\":\"/\",\"data\":1}\n\nevent: put\ndata: {\"path\":\"/\",\"data\":2}\n\n")
+		case req.Method == "DELETE":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.Write([]byte(`{"name":"-K1"}`))
+		}
+	}))
+	defer server.Close()
+	var propagated []interface{}
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		propagated = append(propagated, req.Context().Value(spanKey{}))
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	tracer := &testTracer{}
+	ctx := context.WithValue(context.Background(), spanKey{}, "caller")
+	ref := NewReference(server.URL).WithHttpClient(client).WithTracer(tracer).WithContext(ctx).
+		Ref("users").OrderByKey().LimitToFirst(2)
+
+	var v interface{}
+	assert.NoError(t, ref.Value(&v))
+	_, err := ref.Push(1)
+	assert.NoError(t, err)
+	assert.Error(t, ref.Remove())
+	sub, err := ref.Subscribe()
+	assert.NoError(t, err)
+	for range sub.Events() {
+	}
+	sub.Close()
+
+	assert.Equal(t, []interface{}{"firebasedb.get", "firebasedb.push", "firebasedb.remove", "firebasedb.subscribe"}, propagated)
+	assert.Equal(t, 4, len(tracer.spans))
+	get := tracer.spans[0]
+	assert.Equal(t, "firebasedb.get", get.name)
+	assert.Equal(t, "caller", get.parent)
+	assert.True(t, get.ended)
+	assert.Equal(t, "get", get.attributes[AttrOperation])
+	assert.Equal(t, "/users", get.attributes[AttrPath])
+	assert.Equal(t, "limitToFirst=2&orderBy=%22%24key%22", get.attributes[AttrQuery])
+	assert.Equal(t, 200, get.attributes[AttrStatusCode])
+	assert.Nil(t, get.err)
+
+	remove := tracer.spans[2]
+	assert.Equal(t, 403, remove.attributes[AttrStatusCode])
+	assert.Error(t, remove.err)
+
+	subscribe := tracer.spans[3]
+	assert.True(t, subscribe.ended)
+	assert.Equal(t, 2, subscribe.attributes[AttrEvents])
+}
+
+func TestWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("null"))
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var v interface{}
+	assert.Error(t, NewReference(server.URL).WithContext(ctx).Value(&v))
+	assert.NoError(t, NewReference(server.URL).Value(&v))
+}
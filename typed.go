@@ -0,0 +1,240 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// TypedRef is a reference to a location holding values of type T. It wraps a
+// Reference and decodes the data into T, so that type mistakes are caught by
+// the compiler:
+//
+//	type User struct {
+//		Name string `json:"name"`
+//	}
+//	users := firebasedb.Typed[User](db.Ref("users"))
+//	ref, err := users.Push(User{Name: "Ada"})
+//	user, err := ref.Get()
+type TypedRef[T any] struct {
+	ref *Reference
+}
+
+// Typed returns a typed reference on the location of r.
+func Typed[T any](r *Reference) TypedRef[T] {
+	return TypedRef[T]{ref: r}
+}
+
+// Ref returns the underlying reference.
+func (t TypedRef[T]) Ref() *Reference {
+	return t.ref
+}
+
+// Key returns the last part of the path of the reference.
+func (t TypedRef[T]) Key() string {
+	return t.ref.Key()
+}
+
+// Child returns a typed reference on a child of the location.
+func (t TypedRef[T]) Child(path string) TypedRef[T] {
+	return TypedRef[T]{ref: t.ref.Child(path)}
+}
+
+// Get reads the value at the location of the reference. If the location is
+// empty, the zero value of T is returned.
+func (t TypedRef[T]) Get() (T, error) {
+	var v T
+	err := t.ref.Value(&v)
+	return v, err
+}
+
+// Set writes value at the location of the reference.
+func (t TypedRef[T]) Set(value T) error {
+	return t.ref.Set(value)
+}
+
+// Update updates some of the children of the location. The partial value is
+// usually a map from relative paths to values.
+func (t TypedRef[T]) Update(partial interface{}) error {
+	return t.ref.Update(partial)
+}
+
+// Push adds value to the list at the location of the reference and returns the
+// reference to the new child.
+func (t TypedRef[T]) Push(value T) (TypedRef[T], error) {
+	name, err := t.ref.Push(value)
+	if err != nil {
+		return TypedRef[T]{}, err
+	}
+	return t.Child(name), nil
+}
+
+// decodeChildren decodes a JSON object or array into a map of values. The
+// elements of an array are indexed by their position and the null elements
+// are skipped.
+func decodeChildren[T any](codec Codec, raw json.RawMessage) (map[string]T, error) {
+	items, err := arrayOrObject(raw)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error decoding the result: %v", err))
+	}
+	m := map[string]T{}
+	for k, item := range items {
+		var v T
+		if err := unmarshalValue(codec, item, &v, k); err != nil {
+			return nil, errors.New(fmt.Sprintf("error decoding the result: %v", err))
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// Children reads the children of the location, decoded into T and indexed by
+// their keys.
+func (t TypedRef[T]) Children() (map[string]T, error) {
+	var raw json.RawMessage
+	if err := t.ref.Value(&raw); err != nil {
+		return nil, err
+	}
+	return decodeChildren[T](t.ref.getCodec(), raw)
+}
+
+// TypedEvent is an event of a typed subscription. Value is the complete value at
+// the location of the subscription after the change and Path is the path of the
+// data changed by the event, relative to the location.
+type TypedEvent[T any] struct {
+	Type  string // put, patch, cancel or auth_revoked
+	Path  string
+	Value T
+	Err   error
+}
+
+// TypedSubscription is a subscription returned by TypedRef.Subscribe.
+type TypedSubscription[T any] struct {
+	sub      *Subscription
+	key      string
+	codec    Codec
+	events   chan TypedEvent[T]
+	snapshot interface{}
+}
+
+// Subscribe returns a subscription on the location of the reference. The
+// subscription keeps a local copy of the data, applies the put and patch events
+// to it and sends the decoded value with each event.
+func (t TypedRef[T]) Subscribe() (*TypedSubscription[T], error) {
+	sub, err := t.ref.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	s := &TypedSubscription[T]{sub: sub, key: t.ref.Key(), codec: t.ref.getCodec(), events: make(chan TypedEvent[T])}
+	go s.loop()
+	return s, nil
+}
+
+// Events returns the event channel of the subscription.
+func (s *TypedSubscription[T]) Events() <-chan TypedEvent[T] {
+	return s.events
+}
+
+// Close closes the subscription.
+func (s *TypedSubscription[T]) Close() error {
+	return s.sub.Close()
+}
+
+// patchNode replaces the node at path by value. The arrays on the path are
+// converted to objects.
+func patchNode(tree interface{}, path []string, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	m := map[string]interface{}{}
+	switch old := tree.(type) {
+	case map[string]interface{}:
+		for k, v := range old {
+			m[k] = v
+		}
+	case []interface{}:
+		for i, v := range old {
+			if v != nil {
+				m[strconv.Itoa(i)] = v
+			}
+		}
+	}
+	if child := patchNode(m[path[0]], path[1:], value); child == nil {
+		delete(m, path[0])
+	} else {
+		m[path[0]] = child
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// apply updates the snapshot with a put or patch event.
+func (s *TypedSubscription[T]) apply(e *Event) (path string, err error) {
+	var p struct {
+		Path string      `json:"path"`
+		Data interface{} `json:"data"`
+	}
+	if err = s.codec.Unmarshal([]byte(e.data), &p); err != nil {
+		return "", err
+	}
+	if e.Type == "put" {
+		s.snapshot = patchNode(s.snapshot, splitPath(p.Path), p.Data)
+		return p.Path, nil
+	}
+	values, ok := p.Data.(map[string]interface{})
+	if !ok {
+		return p.Path, errors.New("The data of a patch event must be an object")
+	}
+	for k, v := range values {
+		s.snapshot = patchNode(s.snapshot, append(splitPath(p.Path), splitPath(k)...), v)
+	}
+	return p.Path, nil
+}
+
+// decode converts the snapshot into a value of type T.
+func (s *TypedSubscription[T]) decode() (T, error) {
+	var v T
+	b, err := json.Marshal(s.snapshot)
+	if err == nil {
+		err = unmarshalValue(s.codec, b, &v, s.key)
+	}
+	return v, err
+}
+
+func (s *TypedSubscription[T]) loop() {
+	defer close(s.events)
+	for e := range s.sub.Events() {
+		event := TypedEvent[T]{Type: e.Type, Err: e.Err}
+		switch {
+		case e.Err != nil:
+		case e.Type == "put" || e.Type == "patch":
+			if event.Path, event.Err = s.apply(e); event.Err == nil {
+				event.Value, event.Err = s.decode()
+			}
+		case e.Type == "cancel":
+			event.Err = errors.New("The subscription was cancelled by the server")
+		case e.Type == "auth_revoked":
+			event.Err = errors.New("The credentials of the subscription have expired")
+		default:
+			continue
+		}
+		s.events <- event
+	}
+}
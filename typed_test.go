@@ -0,0 +1,98 @@
+// Copyright 2016 Jacques Supcik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firebasedb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type typedUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age,omitempty"`
+}
+
+func TestTypedRef(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method + " " + req.URL.Path {
+		case "GET /users/ada.json":
+			w.Write([]byte(`{"name":"Ada","age":36}`))
+		case "GET /users.json":
+			w.Write([]byte(`{"ada":{"name":"Ada"},"bob":{"name":"Bob"}}`))
+		case "GET /list.json":
+			w.Write([]byte(`[{"name":"Ada"},null,{"name":"Eve"}]`))
+		case "POST /users.json":
+			w.Write([]byte(`{"name":"-KX1"}`))
+		default:
+			w.Write([]byte("null"))
+		}
+	}))
+	defer server.Close()
+	users := Typed[typedUser](NewReference(server.URL).Ref("users"))
+
+	user, err := users.Child("ada").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, typedUser{Name: "Ada", Age: 36}, user)
+
+	children, err := users.Children()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]typedUser{"ada": {Name: "Ada"}, "bob": {Name: "Bob"}}, children)
+
+	list, err := Typed[typedUser](NewReference(server.URL).Ref("list")).Children()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]typedUser{"0": {Name: "Ada"}, "2": {Name: "Eve"}}, list)
+
+	ref, err := users.Push(typedUser{Name: "Eve"})
+	assert.NoError(t, err)
+	assert.Equal(t, "-KX1", ref.Key())
+
+	user, err = users.Child("nobody").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, typedUser{}, user)
+}
+
+func TestTypedSubscribe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		events := []string{
+			`put`, `{"path":"/","data":{"ada":{"name":"Ada"}}}`,
+			`keep-alive`, `null`,
+			`put`, `{"path":"/bob","data":{"name":"Bob"}}`,
+			`patch`, `{"path":"/ada","data":{"age":36}}`,
+			`put`, `{"path":"/bob","data":null}`,
+		}
+		for i := 0; i < len(events); i += 2 {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", events[i], events[i+1])
+		}
+	}))
+	defer server.Close()
+	sub, err := Typed[map[string]typedUser](NewReference(server.URL).Ref("users")).Subscribe()
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	var got []TypedEvent[map[string]typedUser]
+	for e := range sub.Events() {
+		got = append(got, e)
+	}
+	assert.Equal(t, []TypedEvent[map[string]typedUser]{
+		{Type: "put", Path: "/", Value: map[string]typedUser{"ada": {Name: "Ada"}}},
+		{Type: "put", Path: "/bob", Value: map[string]typedUser{"ada": {Name: "Ada"}, "bob": {Name: "Bob"}}},
+		{Type: "patch", Path: "/ada", Value: map[string]typedUser{"ada": {Name: "Ada", Age: 36}, "bob": {Name: "Bob"}}},
+		{Type: "put", Path: "/bob", Value: map[string]typedUser{"ada": {Name: "Ada", Age: 36}}},
+	}, got)
+}